@@ -1,14 +1,19 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -21,38 +26,134 @@ const (
 var (
 	scrapeDurationDesc *prometheus.Desc
 	scrapeSuccessDesc  *prometheus.Desc
+	timedOutDesc       *prometheus.Desc
+	runDurationDesc    *prometheus.Desc
+	runSuccessDesc     *prometheus.Desc
 	factories          = make(map[string]func() (Collector, error))
 	collectorState     = make(map[string]*bool)
 	labelnames         = []string{"target", "resource"}
 	enableFullMetrics  = kingpin.Flag("enable-full-metrics", "Enable full of metrics").Default("false").Bool()
+
+	// switchNameRegexp matches the name of the switch in the switchshow output:
+	//
+	//	switchName:	SAN1
+	switchNameRegexp = regexp.MustCompile(`(?m)^switchName:\s*(\S+)`)
 )
 
 func init() {
-	scrapeDurationDesc = prometheus.NewDesc(prefix+"collector_duration_seconds", "Duration of a collector scrape for one resource", labelnames, nil) // metric name, help information, Arrar of defined label names, defined labels
+	scrapeDurationDesc = prometheus.NewDesc(prefix+"target_collect_duration_seconds", "Duration of the collection of one resource by all its collectors", labelnames, nil) // metric name, help information, Arrar of defined label names, defined labels
 	scrapeSuccessDesc = prometheus.NewDesc(prefix+"collector_success", "Scrape of resource was sucessful", labelnames, nil)
+	timedOutDesc = prometheus.NewDesc(prefix+"collector_timed_out", "Whether the collector ran out of time before the scrape timeout and returned partial or no results", append(labelnames, "collector"), nil)
+	runDurationDesc = prometheus.NewDesc(prefix+"collector_run_duration_seconds", "Duration of one collector for one resource", append(labelnames, "collector"), nil)
+	runSuccessDesc = prometheus.NewDesc(prefix+"collector_run_success", "Whether one collector succeeded for one resource", append(labelnames, "collector"), nil)
+	reserveLabels(append(labelnames, "collector")...)
+}
+
+// fullMetricsKey is the context key of the full metrics setting of the target being collected
+type fullMetricsKey struct{}
+
+// fullMetrics tells whether the full set of metrics is collected for the target, --enable-full-metrics
+// unless the target sets fullMetrics
+func fullMetrics(ctx context.Context) bool {
+	if enabled, ok := ctx.Value(fullMetricsKey{}).(bool); ok {
+		return enabled
+	}
+	return *enableFullMetrics
+}
+
+// adHocKey is the context key which is set while an ad-hoc target is collected
+type adHocKey struct{}
+
+// targetContext returns the context of the collection of a target, with its full metrics setting
+// and whether it is an ad-hoc target
+func targetContext(ctx context.Context, target connector.Targets) context.Context {
+	if target.AdHoc {
+		ctx = context.WithValue(ctx, adHocKey{}, true)
+	}
+	if target.FullMetrics == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, fullMetricsKey{}, *target.FullMetrics)
+}
+
+// reserveLabels registers labels set by the collectors, static labels of the targets must not use them
+func reserveLabels(names ...string) {
+	for _, name := range names {
+		if !contains(connector.ReservedLabelNames, name) {
+			connector.ReservedLabelNames = append(connector.ReservedLabelNames, name)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetGroup are targets with the same static labels
+type TargetGroup struct {
+	Labels  prometheus.Labels
+	Targets []connector.Targets
+}
+
+// GroupByLabels groups the targets by their static labels. Every group has the same label names,
+// labels a target doesn't set are empty, so that all series of a metric have the same labels.
+func GroupByLabels(targets []connector.Targets) []TargetGroup {
+	names := make(map[string]bool)
+	for _, target := range targets {
+		for name := range target.Labels {
+			names[name] = true
+		}
+	}
+
+	var groups []TargetGroup
+	index := make(map[string]int)
+	for _, target := range targets {
+		labels := prometheus.Labels{}
+		var key []string
+		for name := range names {
+			labels[name] = target.Labels[name]
+			key = append(key, name+"="+target.Labels[name])
+		}
+		sort.Strings(key)
+		k := strings.Join(key, "\x00")
+		i, found := index[k]
+		if !found {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, TargetGroup{Labels: labels})
+		}
+		groups[i].Targets = append(groups[i].Targets, target)
+	}
+	return groups
 }
 
 // fabricosCollector implements the prometheus.Collector interface
 type FabricOSCollector struct {
-	targets    []connector.Targets
-	Collectors map[string]Collector
-	// connectionManager *connector.SSHConnectionManager
+	ctx               context.Context
+	targets           []connector.Targets
+	Collectors        map[string]Collector
+	connectionManager *connector.SSHConnectionManager
 }
 
 //newFabricosCollector creates a new fabric os Collector.
-// func NewFabricOSCollector(targets []string, connectionManager *connector.SSHConnectionManager) (*FabricOSCollector, error) {
-func NewFabricOSCollector(targets []connector.Targets) (*FabricOSCollector, error) {
+// The connection manager is shared by all scrapes, so connections are reused.
+// The collectors give up once ctx is done, usually when the scrape timeout is reached.
+func NewFabricOSCollector(ctx context.Context, targets []connector.Targets, connectionManager *connector.SSHConnectionManager) (*FabricOSCollector, error) {
+	// All collectors are created, as targets may select collectors which are disabled by default
 	collectors := make(map[string]Collector)
-	for key, enabled := range collectorState {
-		if *enabled {
-			collector, err := factories[key]()
-			if err != nil {
-				return nil, err
-			}
-			collectors[key] = collector
+	for key := range collectorState {
+		collector, err := factories[key]()
+		if err != nil {
+			return nil, err
 		}
+		collectors[key] = collector
 	}
-	return &FabricOSCollector{targets, collectors}, nil
+	return &FabricOSCollector{ctx, targets, collectors, connectionManager}, nil
 }
 
 func registerCollector(collector string, isDefaultEnabled bool, factory func() (Collector, error)) {
@@ -71,12 +172,37 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func() (
 	collectorState[collector] = flag
 
 	factories[collector] = factory
+	connector.CollectorNames = append(connector.CollectorNames, collector)
+	if c, err := factory(); err == nil {
+		if _, ok := c.(SNMPCollector); ok {
+			connector.SNMPCollectorNames = append(connector.SNMPCollectorNames, collector)
+		}
+	}
+}
+
+// collectorsForTarget returns the names of the collectors run for a target:
+// those listed for the target, otherwise the collectors enabled on the command line
+func collectorsForTarget(target connector.Targets) []string {
+	if len(target.Collectors) > 0 {
+		return target.Collectors
+	}
+	var names []string
+	for name, enabled := range collectorState {
+		if *enabled {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 //Describe implements the Prometheus.Collector interface.
 func (c FabricOSCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- timedOutDesc
+	ch <- runDurationDesc
+	ch <- runSuccessDesc
+	ch <- lastSuccessDesc
 	for _, col := range c.Collectors {
 		col.Describe(ch)
 	}
@@ -88,58 +214,182 @@ func (c FabricOSCollector) Collect(ch chan<- prometheus.Metric) {
 	wg := &sync.WaitGroup{}
 	wg.Add(len(hosts))
 	for _, h := range hosts {
-		go c.collectForHost(h, ch, wg)
+		go func(h connector.Targets) {
+			defer wg.Done()
+			// Polled targets are served from their last poll
+			if sendSnapshot(h, ch) {
+				return
+			}
+			c.collectShared(h, ch)
+		}(h)
 	}
 	wg.Wait()
 
 }
 
-func (c *FabricOSCollector) collectForHost(host connector.Targets, ch chan<- prometheus.Metric, wg *sync.WaitGroup) {
-	defer wg.Done()
+// collectForHost collects a target and tells whether it succeeded
+func (c *FabricOSCollector) collectForHost(host connector.Targets, ch chan<- prometheus.Metric) bool {
 	start := time.Now()
 	success := 0
 	var hostname string
+	status := &TargetStatus{Target: host.IpAddress, LastScrape: start}
+	logger := logging.With("target", host.IpAddress)
 	defer func() {
 		ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), host.IpAddress, hostname)
 		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, float64(success), host.IpAddress, hostname)
+		status.Duration = time.Since(start)
+		status.Success = success == 1
+		status.SwitchName = hostname
+		recordStatus(status)
 	}()
 
-	connManager, err := connector.NewConnectionManager(host.Userid, host.Password)
+	releaseSwitch, err := acquireSwitch(c.ctx, host.IpAddress)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not collect the target", "err", err)
+		status.Error = err.Error()
+		return false
+	}
+	defer releaseSwitch()
+	release, err := acquireWorker(c.ctx)
 	if err != nil {
-		log.Fatalf("Couldn't initialize connection manager, %v", err)
+		level.Error(logger).Log("msg", "Could not collect the target", "err", err)
+		status.Error = err.Error()
+		return false
 	}
-	defer connManager.Close()
+	defer release()
 
-	conn, err := connManager.Connect(host.IpAddress)
+	ctx := logging.NewContext(withStatus(targetContext(c.ctx, host), status), logger)
+	if host.Transport == connector.TransportSNMP {
+		success, hostname = c.collectSNMPForHost(ctx, host, ch, status)
+		return success == 1
+	}
+
+	conn, err := c.connectionManager.Connect(ctx, host)
 	if err != nil {
-		log.Errorf("Could not connect to %s: %v", host.IpAddress, err)
-		return
+		level.Error(logger).Log("msg", "Could not connect to the target", "err", err)
+		status.Error = err.Error()
+		return false
 	}
-	success = 1
+	defer c.connectionManager.Release(conn)
 
-	fabricResp, err := conn.RunCommand("fabricshow")
+	switchResp, err := runCommand(ctx, conn, "switchshow")
 	if err != nil {
-		log.Errorf("Executing fabricshow command failed: %s", err)
-	}
-	// 	Switch ID   Worldwide Name          Enet IP Addr    FC IP Addr      Name
-	// -------------------------------------------------------------------------
-	//   1: fffc01 10:00:88:94:71:61:5d:73 172.16.64.17    0.0.0.0        >"SAN1"
-	log.Debugln("Response of fabricshow cmd: ", fabricResp)
-	re := regexp.MustCompile(`>"(.*?)"`)
-	hostname = re.FindString(fabricResp)
-	hostname = hostname[2 : len(hostname)-1]
-	log.Debugln("hostname: ", hostname)
+		level.Error(logger).Log("msg", "Executing switchshow command failed", "err", err)
+		status.Error = "switchshow: " + err.Error()
+	}
+	hostname = parseSwitchName(switchResp)
+	level.Debug(logger).Log("hostname", hostname)
 	if hostname != "" {
-		for name, col := range c.Collectors {
-			err = col.Collect(conn, ch, []string{host.IpAddress, hostname})
-			if err != nil && err.Error() != "EOF" {
-				log.Errorln(name + ": " + err.Error())
+		logger = log.With(logger, "switch", hostname)
+		ctx = logging.NewContext(ctx, logger)
+		// The target only succeeds if all of its collectors do
+		success = 1
+		for _, name := range collectorsForTarget(host) {
+			col := c.Collectors[name]
+			timedOut := 0
+			collectorStart := time.Now()
+			err = nil
+			// Once the time is up the remaining collectors are skipped, the metrics collected so far are kept
+			if c.ctx.Err() != nil {
+				timedOut = 1
+			} else {
+				err = col.Collect(withCollector(ctx, name), conn, ch, []string{host.IpAddress, hostname})
+				if err != nil && c.ctx.Err() != nil {
+					timedOut = 1
+				}
+				if err != nil && err.Error() != "EOF" {
+					level.Error(log.With(logger, "collector", name)).Log("err", err)
+				}
+			}
+			if timedOut == 1 {
+				level.Warn(log.With(logger, "collector", name)).Log("msg", "The collector ran out of time", "err", c.ctx.Err())
+			}
+			result := status.collectorDone(name, collectorStart, err, timedOut == 1)
+			if !result.Success {
+				success = 0
 			}
+			sendCollectorMetrics(ch, result, host.IpAddress, hostname)
 		}
 	} else {
-		log.Errorln("The hostname of ", host.IpAddress, "is null, please check if the devcie is enabled.")
+		level.Error(logger).Log("msg", "The hostname of the target is null, please check if the devcie is enabled.")
+		if status.Error == "" {
+			status.Error = "switchshow returned no switch name"
+		}
 	}
+	return success == 1
+}
 
+// collectSNMPForHost collects the metrics of a target configured with the SNMP transport
+func (c *FabricOSCollector) collectSNMPForHost(ctx context.Context, host connector.Targets, ch chan<- prometheus.Metric, status *TargetStatus) (int, string) {
+	logger := logging.FromContext(ctx)
+	conn, err := connector.NewSNMPConnection(ctx, host)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not connect to the target", "err", err)
+		status.Error = err.Error()
+		return 0, ""
+	}
+	defer conn.Close()
+
+	hostname, err := snmpSysName(conn)
+	if err != nil {
+		level.Error(logger).Log("msg", "Reading sysName failed", "err", err)
+		status.Error = "sysName: " + err.Error()
+		return 0, ""
+	}
+	level.Debug(logger).Log("hostname", hostname)
+	logger = log.With(logger, "switch", hostname)
+	ctx = logging.NewContext(ctx, logger)
+	success := 1
+	for _, name := range collectorsForTarget(host) {
+		snmpCol, ok := c.Collectors[name].(SNMPCollector)
+		if !ok {
+			// The collectors of the configuration file are validated, those enabled on the command line or by a module aren't
+			level.Debug(logger).Log("msg", "The collector does not support SNMP, skipping it", "collector", name)
+			continue
+		}
+		timedOut := 0
+		collectorStart := time.Now()
+		err = nil
+		if c.ctx.Err() != nil {
+			timedOut = 1
+		} else if err = snmpCol.CollectSNMP(withCollector(ctx, name), conn, ch, []string{host.IpAddress, hostname}); err != nil {
+			if c.ctx.Err() != nil {
+				timedOut = 1
+			}
+			level.Error(log.With(logger, "collector", name)).Log("err", err)
+		}
+		if timedOut == 1 {
+			level.Warn(log.With(logger, "collector", name)).Log("msg", "The collector ran out of time", "err", c.ctx.Err())
+		}
+		result := status.collectorDone(name, collectorStart, err, timedOut == 1)
+		if !result.Success {
+			success = 0
+		}
+		sendCollectorMetrics(ch, result, host.IpAddress, hostname)
+	}
+	return success, hostname
+}
+
+// parseSwitchName returns the name of the switch from the switchshow output, empty if it isn't found
+func parseSwitchName(switchResp string) string {
+	if match := switchNameRegexp.FindStringSubmatch(switchResp); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// sendCollectorMetrics sends the duration and result of a collector
+func sendCollectorMetrics(ch chan<- prometheus.Metric, result CollectorStatus, target string, hostname string) {
+	var success, timedOut float64
+	if result.Success {
+		success = 1
+	}
+	if result.TimedOut {
+		timedOut = 1
+	}
+	ch <- prometheus.MustNewConstMetric(timedOutDesc, prometheus.GaugeValue, timedOut, target, hostname, result.Name)
+	ch <- prometheus.MustNewConstMetric(runDurationDesc, prometheus.GaugeValue, result.Duration.Seconds(), target, hostname, result.Name)
+	ch <- prometheus.MustNewConstMetric(runSuccessDesc, prometheus.GaugeValue, success, target, hostname, result.Name)
 }
 
 // Collector is the interface a collector has to implement.
@@ -148,6 +398,6 @@ type Collector interface {
 	//Describe describes the metrics
 	Describe(ch chan<- *prometheus.Desc)
 
-	//Collect collects metrics from FabricOS
-	Collect(client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error
+	//Collect collects metrics from FabricOS, giving up once ctx is done
+	Collect(ctx context.Context, client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error
 }
@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestCollectorsForTarget(t *testing.T) {
+	state := make(map[string]bool)
+	for name, enabled := range collectorState {
+		state[name] = *enabled
+		*enabled = name != "sensorshow"
+	}
+	defer func() {
+		for name, enabled := range state {
+			*collectorState[name] = enabled
+		}
+	}()
+
+	names := collectorsForTarget(connector.Targets{})
+	sort.Strings(names)
+	if expected := []string{"portstatsshow", "uptime"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the collectors enabled on the command line %v, got %v", expected, names)
+	}
+
+	// targets may select collectors which are disabled on the command line
+	names = collectorsForTarget(connector.Targets{Collectors: []string{"sensorshow"}})
+	if expected := []string{"sensorshow"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the collectors of the target %v, got %v", expected, names)
+	}
+
+	for _, name := range []string{"portstatsshow", "sensorshow", "uptime"} {
+		found := false
+		for _, registered := range connector.CollectorNames {
+			found = found || registered == name
+		}
+		if !found {
+			t.Errorf("collector %s is not registered for validation", name)
+		}
+	}
+}
+
+func TestGroupByLabels(t *testing.T) {
+	targets := []connector.Targets{
+		{IpAddress: "192.0.2.1", Labels: map[string]string{"site": "fra", "fabric": "a"}},
+		{IpAddress: "192.0.2.2", Labels: map[string]string{"site": "fra", "fabric": "b"}},
+		{IpAddress: "192.0.2.3", Labels: map[string]string{"fabric": "a", "site": "fra"}},
+		{IpAddress: "192.0.2.4"},
+	}
+	groups := GroupByLabels(targets)
+
+	expected := []struct {
+		labels  prometheus.Labels
+		targets []string
+	}{
+		{prometheus.Labels{"site": "fra", "fabric": "a"}, []string{"192.0.2.1", "192.0.2.3"}},
+		{prometheus.Labels{"site": "fra", "fabric": "b"}, []string{"192.0.2.2"}},
+		// labels a target doesn't set are empty, so that all series have the same label names
+		{prometheus.Labels{"site": "", "fabric": ""}, []string{"192.0.2.4"}},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("expected %d groups, got %+v", len(expected), groups)
+	}
+	for i, group := range groups {
+		if !reflect.DeepEqual(group.Labels, expected[i].labels) {
+			t.Errorf("group %d: labels = %v, want %v", i, group.Labels, expected[i].labels)
+		}
+		var addresses []string
+		for _, target := range group.Targets {
+			addresses = append(addresses, target.IpAddress)
+		}
+		if !reflect.DeepEqual(addresses, expected[i].targets) {
+			t.Errorf("group %d: targets = %v, want %v", i, addresses, expected[i].targets)
+		}
+	}
+
+	groups = GroupByLabels([]connector.Targets{{IpAddress: "192.0.2.1"}, {IpAddress: "192.0.2.2"}})
+	if len(groups) != 1 || len(groups[0].Labels) != 0 || len(groups[0].Targets) != 2 {
+		t.Errorf("expected one group without labels, got %+v", groups)
+	}
+}
+
+func TestFullMetrics(t *testing.T) {
+	full := *enableFullMetrics
+	defer func() { *enableFullMetrics = full }()
+	enabled, disabled := true, false
+
+	for _, flag := range []bool{false, true} {
+		*enableFullMetrics = flag
+		if got := fullMetrics(targetContext(context.Background(), connector.Targets{})); got != flag {
+			t.Errorf("flag %v: expected the flag without override, got %v", flag, got)
+		}
+		if got := fullMetrics(targetContext(context.Background(), connector.Targets{FullMetrics: &enabled})); !got {
+			t.Errorf("flag %v: expected the target to enable full metrics", flag)
+		}
+		if got := fullMetrics(targetContext(context.Background(), connector.Targets{FullMetrics: &disabled})); got {
+			t.Errorf("flag %v: expected the target to disable full metrics", flag)
+		}
+	}
+}
+
+func TestReservedLabels(t *testing.T) {
+	for _, name := range []string{"target", "resource", "collector", "version", "portIndex", "status"} {
+		if !contains(connector.ReservedLabelNames, name) {
+			t.Errorf("expected label %s to be reserved", name)
+		}
+	}
+}
+
+func TestCollectSwitchName(t *testing.T) {
+	defer setLimits(0, 0)()
+	// the name is that of the collected switch, not of the principal switch of its fabric
+	s := newSSHSwitch(t, map[string]string{
+		"switchshow": "switchName:\tSAN2\nswitchType:\t162.0\nswitchState:\tOnline\n",
+		"sensorshow": "",
+	})
+	defer s.close()
+	m := s.manager(t)
+	defer m.Close()
+	target := s.target()
+	target.Collectors = []string{"sensorshow"}
+	c, err := NewFabricOSCollector(context.Background(), nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gather(func(ch chan<- prometheus.Metric) { c.collectForHost(target, ch) })
+	status, found := LastStatus(target.IpAddress)
+	if !found || status.SwitchName != "SAN2" {
+		t.Errorf("expected the switch name SAN2, got %+v", status)
+	}
+
+	for output, expected := range map[string]string{
+		"switchName:\tSAN1\r\nswitchType:\t162.0\r\n": "SAN1",
+		"switchName: fra1-edge-a\n":                   "fra1-edge-a",
+		"switchType:\t162.0\n":                        "",
+		"":                                            "",
+	} {
+		if name := parseSwitchName(output); name != expected {
+			t.Errorf("parseSwitchName(%q) = %q, want %q", output, name, expected)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+var (
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prefix + "exporter_ssh_command_duration_seconds",
+		Help:    "Duration of a command run on a switch, from sending it until its whole output was received.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"command"})
+	commandReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "exporter_command_received_bytes_total",
+		Help: "Number of bytes of command output received from the switches.",
+	}, []string{"command"})
+	commandFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "exporter_command_failures_total",
+		Help: "Number of commands which failed or ran out of time.",
+	}, []string{"target", "collector", "command"})
+	parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prefix + "exporter_parse_errors_total",
+		Help: "Number of command outputs or SNMP values which could not be parsed.",
+	}, []string{"target", "collector"})
+
+	// targetSeries are the label values of the series of commandFailures and parseErrors per target,
+	// so that they can be deleted once the target is removed
+	targetSeries   = make(map[string]map[labelledSeries]bool)
+	targetSeriesMu sync.Mutex
+)
+
+// labelledSeries is a series of a metric labelled with the target, values are its other label values
+type labelledSeries struct {
+	metric *prometheus.CounterVec
+	values string
+}
+
+// ExporterMetrics returns the metrics about the commands run by the collectors, they are
+// registered with the metrics about the exporter itself
+func ExporterMetrics() []prometheus.Collector {
+	return []prometheus.Collector{commandDuration, commandReceivedBytes, commandFailures, parseErrors}
+}
+
+// collectorKey is the context key of the name of the running collector
+type collectorKey struct{}
+
+// withCollector returns the context of a collector, its log lines have the collector field
+func withCollector(ctx context.Context, name string) context.Context {
+	ctx = logging.NewContext(ctx, log.With(logging.FromContext(ctx), "collector", name))
+	return context.WithValue(ctx, collectorKey{}, name)
+}
+
+// collectorName returns the name of the running collector, empty for the commands run before the collectors
+func collectorName(ctx context.Context) string {
+	name, _ := ctx.Value(collectorKey{}).(string)
+	return name
+}
+
+// targetName returns the address of the target being collected
+func targetName(ctx context.Context) string {
+	if status, ok := ctx.Value(statusKey{}).(*TargetStatus); ok {
+		return status.Target
+	}
+	return ""
+}
+
+// countFailure increments the series of the target being collected. Ad-hoc targets are counted
+// without the target label, they can be scraped under any name and are never removed.
+func countFailure(ctx context.Context, metric *prometheus.CounterVec, values ...string) {
+	target := targetName(ctx)
+	if adHoc, _ := ctx.Value(adHocKey{}).(bool); adHoc {
+		target = ""
+	}
+	metric.WithLabelValues(append([]string{target}, values...)...).Inc()
+	if target == "" {
+		return
+	}
+
+	targetSeriesMu.Lock()
+	defer targetSeriesMu.Unlock()
+	if targetSeries[target] == nil {
+		targetSeries[target] = make(map[labelledSeries]bool)
+	}
+	targetSeries[target][labelledSeries{metric, strings.Join(values, "\xff")}] = true
+}
+
+// deleteRemovedTargets deletes the series of the targets which are no longer configured
+func deleteRemovedTargets(targets []connector.Targets) {
+	configured := make(map[string]bool)
+	for _, t := range targets {
+		configured[t.IpAddress] = true
+	}
+
+	targetSeriesMu.Lock()
+	defer targetSeriesMu.Unlock()
+	for target, series := range targetSeries {
+		if configured[target] {
+			continue
+		}
+		for s := range series {
+			s.metric.DeleteLabelValues(append([]string{target}, strings.Split(s.values, "\xff")...)...)
+		}
+		delete(targetSeries, target)
+	}
+}
+
+// runCommand runs a command on the switch and records its duration, the size of its output and whether it failed.
+// The output is written to the command output file if the target logs at debug level.
+func runCommand(ctx context.Context, client *connector.SSHConnection, cmd string) (string, error) {
+	logger := log.With(logging.FromContext(ctx), "command", cmd)
+	ctx = logging.NewContext(ctx, logger)
+
+	// The arguments, e.g. the port range of portstatsshow, are left out of the labels
+	name := cmd
+	if fields := strings.Fields(cmd); len(fields) > 0 {
+		name = fields[0]
+	}
+	start := time.Now()
+	output, err := client.RunCommand(ctx, cmd)
+	commandDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	commandReceivedBytes.WithLabelValues(name).Add(float64(len(output)))
+	if err != nil {
+		countFailure(ctx, commandFailures, collectorName(ctx), name)
+		return output, err
+	}
+	logging.CommandOutput(logger, output)
+	return output, nil
+}
+
+// RunCommand runs a command on a target outside of the collections, e.g. fabricshow for the discovery.
+// It waits until no collection of the switch is running and records the command like those of the
+// collectors, with the collector label set to name.
+func RunCommand(ctx context.Context, connectionManager *connector.SSHConnectionManager, target connector.Targets, name string, cmd string) (string, error) {
+	releaseSwitch, err := acquireSwitch(ctx, target.IpAddress)
+	if err != nil {
+		return "", err
+	}
+	defer releaseSwitch()
+
+	ctx = logging.NewContext(ctx, logging.With("target", target.IpAddress))
+	ctx = withCollector(withStatus(targetContext(ctx, target), &TargetStatus{Target: target.IpAddress}), name)
+	conn, err := connectionManager.Connect(ctx, target)
+	if err != nil {
+		return "", errors.Wrap(err, "could not connect")
+	}
+	defer connectionManager.Release(conn)
+	return runCommand(ctx, conn, cmd)
+}
+
+// parseError logs a value which could not be parsed and counts it
+func parseError(ctx context.Context, format string, args ...interface{}) {
+	level.Error(logging.FromContext(ctx)).Log("msg", fmt.Sprintf(format, args...))
+	countFailure(ctx, parseErrors, collectorName(ctx))
+}
@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestRunCommand(t *testing.T) {
+	s := newSSHSwitch(t, map[string]string{"portstatsshow 0": "port 0 statistics\n"})
+	defer s.close()
+	m := s.manager(t)
+	defer m.Close()
+	target := s.target()
+	conn, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(conn)
+
+	status := &TargetStatus{Target: target.IpAddress}
+	ctx := withCollector(withStatus(context.Background(), status), "portstats")
+	bytes := testutil.ToFloat64(commandReceivedBytes.WithLabelValues("portstatsshow"))
+	durations := commandDurationCount("portstatsshow")
+
+	output, err := runCommand(ctx, conn, "portstatsshow 0")
+	if err != nil || output != "port 0 statistics\n" {
+		t.Fatalf("runCommand() = %q, %v", output, err)
+	}
+	if n := testutil.ToFloat64(commandReceivedBytes.WithLabelValues("portstatsshow")) - bytes; n != float64(len(output)) {
+		t.Errorf("expected %d bytes received, got %v", len(output), n)
+	}
+	if n := commandDurationCount("portstatsshow") - durations; n != 1 {
+		t.Errorf("expected 1 observed duration, got %d", n)
+	}
+	if n := testutil.ToFloat64(commandFailures.WithLabelValues(target.IpAddress, "portstats", "portstatsshow")); n != 0 {
+		t.Errorf("expected no failure, got %v", n)
+	}
+
+	// the arguments are left out of the labels
+	if _, err := runCommand(ctx, conn, "portstatsshow 1"); err == nil {
+		t.Fatal("expected the unknown command to fail")
+	}
+	if n := testutil.ToFloat64(commandFailures.WithLabelValues(target.IpAddress, "portstats", "portstatsshow")); n != 1 {
+		t.Errorf("expected 1 failure, got %v", n)
+	}
+	if n := commandDurationCount("portstatsshow") - durations; n != 2 {
+		t.Errorf("expected the duration of the failed command to be observed, got %d", n)
+	}
+}
+
+func TestRunCommandOnTarget(t *testing.T) {
+	s := newSSHSwitch(t, map[string]string{"fabricshow": "The Fabric has 1 switch\n"})
+	defer s.close()
+	m := s.manager(t)
+	defer m.Close()
+	target := s.target()
+
+	output, err := RunCommand(context.Background(), m, target, "discovery", "fabricshow")
+	if err != nil || output != "The Fabric has 1 switch\n" {
+		t.Fatalf("RunCommand() = %q, %v", output, err)
+	}
+	if _, err := RunCommand(context.Background(), m, target, "discovery", "nsshow"); err == nil {
+		t.Fatal("expected the unknown command to fail")
+	}
+	if n := testutil.ToFloat64(commandFailures.WithLabelValues(target.IpAddress, "discovery", "nsshow")); n != 1 {
+		t.Errorf("expected the failure to be counted for the discovery, got %v", n)
+	}
+
+	// the command waits for the collection of the switch
+	release, err := acquireSwitch(context.Background(), target.IpAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := RunCommand(ctx, m, target, "discovery", "fabricshow"); err == nil {
+		t.Error("expected the command to wait for the collection of the switch")
+	}
+	release()
+	if len(switchLocks) != 0 {
+		t.Errorf("expected the switch to be released, got %d locks", len(switchLocks))
+	}
+}
+
+func TestParseError(t *testing.T) {
+	ctx := withCollector(withStatus(context.Background(), &TargetStatus{Target: "192.0.2.1"}), "sensor")
+	parseError(ctx, "could not parse %q", "n/a")
+	if n := testutil.ToFloat64(parseErrors.WithLabelValues("192.0.2.1", "sensor")); n != 1 {
+		t.Errorf("expected 1 parse error, got %v", n)
+	}
+	if name := collectorName(context.Background()); name != "" {
+		t.Errorf("expected no collector outside of a collector, got %q", name)
+	}
+}
+
+// parseErrorTargets returns the target labels of the parse error series
+func parseErrorTargets() map[string]bool {
+	ch := make(chan prometheus.Metric, 100)
+	parseErrors.Collect(ch)
+	close(ch)
+	targets := make(map[string]bool)
+	for m := range ch {
+		var metric dto.Metric
+		m.Write(&metric)
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "target" {
+				targets[label.GetValue()] = true
+			}
+		}
+	}
+	return targets
+}
+
+func TestDeleteRemovedTargets(t *testing.T) {
+	for _, target := range []connector.Targets{{IpAddress: "192.0.2.10"}, {IpAddress: "192.0.2.11"}, {IpAddress: "192.0.2.12", AdHoc: true}} {
+		ctx := withCollector(withStatus(targetContext(context.Background(), target), &TargetStatus{Target: target.IpAddress}), "sensor")
+		parseError(ctx, "could not parse %q", "n/a")
+	}
+	targets := parseErrorTargets()
+	if !targets["192.0.2.10"] || !targets["192.0.2.11"] || !targets[""] || targets["192.0.2.12"] {
+		t.Fatalf("expected the configured targets and an unlabelled ad-hoc target, got %v", targets)
+	}
+
+	UpdatePolling([]connector.Targets{{IpAddress: "192.0.2.11"}}, nil)
+	targets = parseErrorTargets()
+	if targets["192.0.2.10"] || !targets["192.0.2.11"] {
+		t.Errorf("expected only the series of the removed target to be deleted, got %v", targets)
+	}
+	if _, found := targetSeries["192.0.2.10"]; found {
+		t.Error("expected the removed target to be forgotten")
+	}
+	UpdatePolling(nil, nil)
+}
+
+func commandDurationCount(command string) uint64 {
+	var metric dto.Metric
+	commandDuration.WithLabelValues(command).(prometheus.Metric).Write(&metric)
+	return metric.GetHistogram().GetSampleCount()
+}
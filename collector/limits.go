@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	maxConcurrency = kingpin.Flag("scrape.max-concurrency", "Maximum number of targets collected at the same time by all scrapes (0 means no limit).").Default("16").Int()
+	maxQueue       = kingpin.Flag("scrape.max-queue", "Maximum number of targets waiting for a free worker, further scrapes are rejected with 429 (0 means no limit).").Default("256").Int()
+
+	// ErrQueueFull is returned by Admit when too many targets are waiting to be collected
+	ErrQueueFull = errors.New("too many targets are waiting to be collected")
+
+	workers     chan struct{}
+	workersOnce sync.Once
+	pending     int
+	pendingMu   sync.Mutex
+
+	// flights are the collections in progress, which concurrent scrapes of the same target share
+	flights   = make(map[string]*flight)
+	flightsMu sync.Mutex
+
+	// switchLocks serialize the collections of a switch which can't be shared, e.g. with other collectors
+	switchLocks   = make(map[string]*switchLock)
+	switchLocksMu sync.Mutex
+)
+
+// switchLock is held by the collection of a switch, refs counts the holder and the waiters
+type switchLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+// flight is the collection of a target whose metrics are handed to every scrape waiting for it
+type flight struct {
+	done     chan struct{}
+	deadline time.Time
+	metrics  []prometheus.Metric
+	success  bool
+}
+
+// Admit reserves room for the collection of n targets. It fails with ErrQueueFull if the targets
+// would have to wait behind more than --scrape.max-queue others. The returned function releases the room.
+func Admit(n int) (func(), error) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	if *maxConcurrency > 0 && *maxQueue > 0 && pending > 0 && pending+n > *maxConcurrency+*maxQueue {
+		return nil, ErrQueueFull
+	}
+	pending += n
+	return func() {
+		pendingMu.Lock()
+		pending -= n
+		pendingMu.Unlock()
+	}, nil
+}
+
+// acquireWorker waits for a free worker, it returns the function releasing it
+func acquireWorker(ctx context.Context) (func(), error) {
+	if *maxConcurrency <= 0 {
+		return func() {}, nil
+	}
+	workersOnce.Do(func() {
+		workers = make(chan struct{}, *maxConcurrency)
+	})
+	select {
+	case workers <- struct{}{}:
+		return func() { <-workers }, nil
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "no worker was free before the scrape timeout")
+	}
+}
+
+// acquireSwitch waits until no other collection of the switch is running, it returns the function releasing it
+func acquireSwitch(ctx context.Context, address string) (func(), error) {
+	switchLocksMu.Lock()
+	l, found := switchLocks[address]
+	if !found {
+		l = &switchLock{sem: make(chan struct{}, 1)}
+		switchLocks[address] = l
+	}
+	l.refs++
+	switchLocksMu.Unlock()
+
+	unref := func() {
+		switchLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(switchLocks, address)
+		}
+		switchLocksMu.Unlock()
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return func() {
+			<-l.sem
+			unref()
+		}, nil
+	case <-ctx.Done():
+		unref()
+		return nil, errors.Wrap(ctx.Err(), "another collection of the switch didn't end before the scrape timeout")
+	}
+}
+
+// flightKey identifies the collections which produce the same metrics
+func flightKey(target connector.Targets) string {
+	collectors := append([]string(nil), collectorsForTarget(target)...)
+	sort.Strings(collectors)
+	full := *enableFullMetrics
+	if target.FullMetrics != nil {
+		full = *target.FullMetrics
+	}
+	key := []string{target.Userid + "@" + target.IpAddress, strings.Join(collectors, ",")}
+	if full {
+		key = append(key, "full")
+	}
+	return strings.Join(key, "|")
+}
+
+// collectShared collects a target, or waits for the collection already in progress for another scrape
+// or a background poll and sends its metrics. One switch thus only has one collection in flight.
+// It tells whether the collection succeeded, false if it wasn't waited for.
+func (c *FabricOSCollector) collectShared(host connector.Targets, ch chan<- prometheus.Metric) bool {
+	key := flightKey(host)
+	flightsMu.Lock()
+	if f, found := flights[key]; found {
+		flightsMu.Unlock()
+		// A collection ending before the deadline of this scrape is waited for even if both
+		// deadlines are so close that this scrape would time out first
+		deadline, ok := c.ctx.Deadline()
+		wait := c.ctx.Done()
+		if ok && !f.deadline.IsZero() && !f.deadline.After(deadline) {
+			wait = nil
+		}
+		select {
+		case <-f.done:
+			for _, m := range f.metrics {
+				ch <- m
+			}
+			return f.success
+		case <-wait:
+			return false
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	f.deadline, _ = c.ctx.Deadline()
+	flights[key] = f
+	flightsMu.Unlock()
+
+	f.metrics = gather(func(ch chan<- prometheus.Metric) {
+		f.success = c.collectForHost(host, ch)
+	})
+
+	flightsMu.Lock()
+	delete(flights, key)
+	flightsMu.Unlock()
+	close(f.done)
+
+	for _, m := range f.metrics {
+		ch <- m
+	}
+	return f.success
+}
+
+// gather returns the metrics sent by collect
+func gather(collect func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	buffer := make(chan prometheus.Metric)
+	collected := make(chan struct{})
+	go func() {
+		for m := range buffer {
+			metrics = append(metrics, m)
+		}
+		close(collected)
+	}()
+	collect(buffer)
+	close(buffer)
+	<-collected
+	return metrics
+}
@@ -0,0 +1,262 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// setLimits sets the scrape limits and resets the workers, the returned function restores them
+func setLimits(concurrency, queue int) func() {
+	oldConcurrency, oldQueue := *maxConcurrency, *maxQueue
+	*maxConcurrency, *maxQueue = concurrency, queue
+	workers, workersOnce = nil, sync.Once{}
+	return func() {
+		*maxConcurrency, *maxQueue = oldConcurrency, oldQueue
+		workers, workersOnce = nil, sync.Once{}
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// collectCount collects the target and returns the number of metrics
+func collectCount(c *FabricOSCollector, collect func(ch chan<- prometheus.Metric)) int {
+	ch := make(chan prometheus.Metric)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range ch {
+			n++
+		}
+		done <- n
+	}()
+	collect(ch)
+	close(ch)
+	return <-done
+}
+
+func TestAdmit(t *testing.T) {
+	defer setLimits(2, 1)()
+
+	first, err := Admit(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Admit(1)
+	if err != nil {
+		t.Fatalf("expected a target to wait in the queue: %v", err)
+	}
+	if _, err := Admit(1); err != ErrQueueFull {
+		t.Errorf("expected the full queue to be reported, got %v", err)
+	}
+	second()
+	first()
+
+	// a scrape is always admitted if nothing else is pending, however many targets it has
+	release, err := Admit(10)
+	if err != nil {
+		t.Fatalf("expected a single scrape to be admitted: %v", err)
+	}
+	release()
+
+	*maxQueue = 0
+	var releases []func()
+	for i := 0; i < 5; i++ {
+		release, err := Admit(2)
+		if err != nil {
+			t.Fatalf("expected no limit without a queue size: %v", err)
+		}
+		releases = append(releases, release)
+	}
+	for _, release := range releases {
+		release()
+	}
+	if pending != 0 {
+		t.Errorf("expected nothing to be pending, got %d", pending)
+	}
+}
+
+func TestAcquireWorker(t *testing.T) {
+	defer setLimits(1, 0)()
+
+	release, err := acquireWorker(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireWorker(ctx); err == nil {
+		t.Fatal("expected no worker to be free before the timeout")
+	}
+	release()
+
+	release, err = acquireWorker(context.Background())
+	if err != nil {
+		t.Fatalf("expected the released worker to be free: %v", err)
+	}
+	release()
+
+	*maxConcurrency = 0
+	workers, workersOnce = nil, sync.Once{}
+	for i := 0; i < 3; i++ {
+		if _, err := acquireWorker(context.Background()); err != nil {
+			t.Fatalf("expected no limit without a concurrency: %v", err)
+		}
+	}
+}
+
+func TestAcquireSwitch(t *testing.T) {
+	release, err := acquireSwitch(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireSwitch(ctx, "192.0.2.1"); err == nil {
+		t.Fatal("expected the switch to be busy until the timeout")
+	}
+
+	// other switches are collected meanwhile
+	other, err := acquireSwitch(context.Background(), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	acquired := make(chan func())
+	go func() {
+		release, err := acquireSwitch(context.Background(), "192.0.2.1")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the switch to be busy")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the released switch to be acquired")
+	}
+
+	switchLocksMu.Lock()
+	defer switchLocksMu.Unlock()
+	if len(switchLocks) != 0 {
+		t.Errorf("expected the locks to be removed once released, got %d", len(switchLocks))
+	}
+}
+
+func TestGather(t *testing.T) {
+	desc := prometheus.NewDesc("test_metric", "Test metric.", nil, nil)
+	metrics := gather(func(ch chan<- prometheus.Metric) {
+		for i := 0; i < 3; i++ {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(i))
+		}
+	})
+	if len(metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %d", len(metrics))
+	}
+	if metrics := gather(func(ch chan<- prometheus.Metric) {}); len(metrics) != 0 {
+		t.Errorf("expected no metrics, got %d", len(metrics))
+	}
+}
+
+func TestCollectShared(t *testing.T) {
+	defer setLimits(0, 0)()
+	agent := newSNMPAgent(t, "testdata/snmp/fos.snmprec")
+	defer agent.close()
+	target := agent.target()
+	target.Collectors = []string{"uptime"}
+	c, err := NewFabricOSCollector(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := collectCount(c, func(ch chan<- prometheus.Metric) { c.collectShared(target, ch) })
+	requests := agent.requestCount()
+
+	// a scrape of the same target while it is collected waits for that collection
+	agent.setDelay(20 * time.Millisecond)
+	counts := make(chan int, 2)
+	go func() {
+		counts <- collectCount(c, func(ch chan<- prometheus.Metric) { c.collectShared(target, ch) })
+	}()
+	waitFor(t, 2*time.Second, func() bool {
+		flightsMu.Lock()
+		defer flightsMu.Unlock()
+		return len(flights) == 1
+	})
+	go func() {
+		counts <- collectCount(c, func(ch chan<- prometheus.Metric) { c.collectShared(target, ch) })
+	}()
+	for i := 0; i < 2; i++ {
+		if n := <-counts; n != expected {
+			t.Errorf("expected %d metrics, got %d", expected, n)
+		}
+	}
+	if n := agent.requestCount() - requests; n != requests {
+		t.Errorf("expected the scrapes to share one collection of %d requests, got %d", requests, n)
+	}
+	if len(flights) != 0 {
+		t.Errorf("expected the flight to be removed, got %d", len(flights))
+	}
+
+	// other collectors don't share the flight
+	other := target
+	other.Collectors = []string{"uptime", "sensor"}
+	if flightKey(other) == flightKey(target) {
+		t.Error("expected targets with other collectors to have another flight")
+	}
+}
+
+func TestCollectCancelledReleasesWorker(t *testing.T) {
+	defer setLimits(1, 0)()
+	agent := newSNMPAgent(t, "testdata/snmp/fos.snmprec")
+	defer agent.close()
+	agent.setDelay(50 * time.Millisecond)
+	target := agent.target()
+	target.Collectors = []string{"uptime", "sensor"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	c, err := NewFabricOSCollector(ctx, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectCount(c, func(ch chan<- prometheus.Metric) { c.collectShared(target, ch) })
+	if ctx.Err() == nil {
+		t.Fatal("expected the collection to run into the timeout")
+	}
+
+	if n := len(workers); n != 0 {
+		t.Errorf("expected the worker to be released, %d are in use", n)
+	}
+	if len(flights) != 0 {
+		t.Errorf("expected the flight to be removed, got %d", len(flights))
+	}
+	if len(switchLocks) != 0 {
+		t.Errorf("expected the switch to be released, got %d locks", len(switchLocks))
+	}
+	release, err := acquireWorker(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+var (
+	lastSuccessDesc = prometheus.NewDesc(prefix+"last_successful_collect_timestamp_seconds", "Timestamp of the last successful background poll of a target, 0 if no poll succeeded yet", labelnames, nil)
+
+	// pollers are the targets collected in the background by the flightKey of their collection
+	pollers   = make(map[string]*poller)
+	pollersMu sync.Mutex
+)
+
+// poller collects a target every pollInterval and keeps the metrics of the last poll
+type poller struct {
+	target connector.Targets
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	polled      bool
+	metrics     []prometheus.Metric
+	lastSuccess time.Time
+	switchName  string
+}
+
+// UpdatePolling starts polling the targets which set a pollInterval and stops polling the others.
+// A target whose settings changed is polled again from scratch, of targets with the same collection
+// only the first is polled. The failure counters of the targets which were removed are deleted.
+func UpdatePolling(targets []connector.Targets, connectionManager *connector.SSHConnectionManager) {
+	deleteRemovedTargets(targets)
+
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+
+	polled := make(map[string]bool)
+	for _, t := range targets {
+		if pollInterval(t) <= 0 {
+			continue
+		}
+		key := flightKey(t)
+		if polled[key] {
+			continue
+		}
+		polled[key] = true
+		if p, found := pollers[key]; found {
+			if reflect.DeepEqual(p.target, t) {
+				continue
+			}
+			p.cancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		p := &poller{target: t, cancel: cancel}
+		pollers[key] = p
+		level.Info(logging.With("target", t.IpAddress)).Log("msg", "Polling the target", "interval", pollInterval(t))
+		go p.run(ctx, connectionManager)
+	}
+	for key, p := range pollers {
+		if !polled[key] {
+			level.Info(logging.With("target", p.target.IpAddress)).Log("msg", "Stopped polling the target")
+			p.cancel()
+			delete(pollers, key)
+		}
+	}
+}
+
+// pollInterval returns the interval at which a target is polled, 0 if it is collected on every scrape
+func pollInterval(t connector.Targets) time.Duration {
+	if t.PollInterval == nil {
+		return 0
+	}
+	return *t.PollInterval
+}
+
+func (p *poller) run(ctx context.Context, connectionManager *connector.SSHConnectionManager) {
+	ticker := time.NewTicker(pollInterval(p.target))
+	defer ticker.Stop()
+	for {
+		p.poll(ctx, connectionManager)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll collects the target, a poll must end before the next one is due
+func (p *poller) poll(ctx context.Context, connectionManager *connector.SSHConnectionManager) {
+	ctx, cancel := context.WithTimeout(ctx, pollInterval(p.target))
+	defer cancel()
+
+	c, err := NewFabricOSCollector(ctx, []connector.Targets{p.target}, connectionManager)
+	if err != nil {
+		level.Error(logging.With("target", p.target.IpAddress)).Log("msg", "Couldn't create the collector polling the target", "err", err)
+		return
+	}
+	start := time.Now()
+	var success bool
+	// A scrape which started before the target was polled may be collecting it already
+	metrics := gather(func(ch chan<- prometheus.Metric) {
+		success = c.collectShared(p.target, ch)
+	})
+	if ctx.Err() == context.Canceled {
+		// The target was removed or changed while it was polled
+		return
+	}
+	level.Debug(logging.With("target", p.target.IpAddress)).Log("msg", "Polled the target", "duration", time.Since(start), "success", success)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.polled = true
+	p.metrics = metrics
+	if success {
+		p.lastSuccess = start
+		status, _ := LastStatus(p.target.IpAddress)
+		p.switchName = status.SwitchName
+	}
+}
+
+// sendSnapshot sends the metrics of the last poll of a target and tells whether they were sent.
+// Scrapes with a module selecting other collectors than the polled ones are collected as usual,
+// like the scrapes before the first poll ended, which share the collection of that poll.
+func sendSnapshot(target connector.Targets, ch chan<- prometheus.Metric) bool {
+	pollersMu.Lock()
+	p, found := pollers[flightKey(target)]
+	pollersMu.Unlock()
+	if !found {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.polled {
+		return false
+	}
+	for _, m := range p.metrics {
+		ch <- m
+	}
+	lastSuccess := 0.0
+	if !p.lastSuccess.IsZero() {
+		lastSuccess = float64(p.lastSuccess.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, lastSuccess, target.IpAddress, p.switchName)
+	return true
+}
@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func pollerOf(target connector.Targets) *poller {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+	return pollers[flightKey(target)]
+}
+
+// snapshot returns the metrics sent for a polled target and the value of its last successful poll
+func snapshot(target connector.Targets) ([]prometheus.Metric, float64, bool) {
+	var polled bool
+	metrics := gather(func(ch chan<- prometheus.Metric) {
+		polled = sendSnapshot(target, ch)
+	})
+	lastSuccess := -1.0
+	for _, m := range metrics {
+		if m.Desc() == lastSuccessDesc {
+			var metric dto.Metric
+			m.Write(&metric)
+			lastSuccess = metric.GetGauge().GetValue()
+		}
+	}
+	return metrics, lastSuccess, polled
+}
+
+func TestPolling(t *testing.T) {
+	agent := newSNMPAgent(t, "testdata/snmp/fos.snmprec")
+	defer agent.close()
+	interval := time.Hour
+	target := agent.target()
+	target.Collectors = []string{"uptime"}
+	target.PollInterval = &interval
+	unpolled := connector.Targets{IpAddress: "192.0.2.1"}
+
+	UpdatePolling([]connector.Targets{target, unpolled}, nil)
+	if pollerOf(unpolled) != nil {
+		t.Error("expected a target without pollInterval not to be polled")
+	}
+	p := pollerOf(target)
+	if p == nil {
+		t.Fatal("expected the target to be polled")
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		_, lastSuccess, _ := snapshot(target)
+		return lastSuccess > 0
+	})
+
+	// scrapes are served from the last poll without asking the switch
+	requests := agent.requestCount()
+	metrics, _, polled := snapshot(target)
+	if !polled {
+		t.Fatal("expected the scrape to be served from the snapshot")
+	}
+	if n := agent.requestCount() - requests; n != 0 {
+		t.Errorf("expected the snapshot not to ask the agent, got %d requests", n)
+	}
+	c, err := NewFabricOSCollector(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := collectCount(c, func(ch chan<- prometheus.Metric) { c.collectForHost(target, ch) })
+	if len(metrics) != expected+1 {
+		t.Errorf("expected the %d collected metrics and the last successful poll, got %d", expected, len(metrics))
+	}
+
+	// a module selecting other collectors is collected as usual
+	other := target
+	other.Collectors = []string{"sensor"}
+	if _, _, polled := snapshot(other); polled {
+		t.Error("expected a scrape with other collectors not to use the snapshot")
+	}
+
+	// unchanged targets keep their poller, changed ones are polled from scratch
+	UpdatePolling([]connector.Targets{target}, nil)
+	if pollerOf(target) != p {
+		t.Error("expected the poller of the unchanged target to be kept")
+	}
+	changed := target
+	changed.Labels = map[string]string{"site": "fra"}
+	UpdatePolling([]connector.Targets{changed}, nil)
+	if q := pollerOf(changed); q == nil || q == p {
+		t.Fatal("expected the changed target to get a new poller")
+	}
+	// the poller is stopped while it waits for the next poll
+	waitFor(t, 5*time.Second, func() bool {
+		_, lastSuccess, _ := snapshot(changed)
+		return lastSuccess > 0
+	})
+
+	UpdatePolling([]connector.Targets{unpolled}, nil)
+	if pollerOf(target) != nil {
+		t.Error("expected the removed target not to be polled")
+	}
+	if _, _, polled := snapshot(target); polled {
+		t.Error("expected the target to be collected on every scrape again")
+	}
+}
+
+func TestSnapshotBeforeFirstPoll(t *testing.T) {
+	defer UpdatePolling(nil, nil)
+	interval := time.Hour
+	target := connector.Targets{IpAddress: "192.0.2.1", Collectors: []string{"uptime"}, PollInterval: &interval}
+	p := &poller{target: target, cancel: func() {}}
+	pollersMu.Lock()
+	pollers[flightKey(target)] = p
+	pollersMu.Unlock()
+
+	// until the first poll ended the target is collected as usual
+	if _, _, polled := snapshot(target); polled {
+		t.Error("expected no snapshot before the first poll")
+	}
+
+	p.mu.Lock()
+	p.polled = true
+	p.mu.Unlock()
+	metrics, lastSuccess, polled := snapshot(target)
+	if !polled || len(metrics) != 1 || lastSuccess != 0 {
+		t.Errorf("expected the failed poll to be served with last successful 0, got %d metrics, %v", len(metrics), lastSuccess)
+	}
+}
+
+func TestPollersByCollection(t *testing.T) {
+	defer UpdatePolling(nil, nil)
+	interval := time.Hour
+	// unreachable targets, whose polls fail at once
+	uptime := connector.Targets{IpAddress: "127.0.0.1:1", Transport: connector.TransportSNMP, Collectors: []string{"uptime"}, PollInterval: &interval}
+	sensor := uptime
+	sensor.Collectors = []string{"sensorshow"}
+	duplicate := uptime
+	duplicate.Labels = map[string]string{"site": "fra"}
+
+	UpdatePolling([]connector.Targets{uptime, sensor, duplicate}, nil)
+	pollersMu.Lock()
+	n := len(pollers)
+	pollersMu.Unlock()
+	if n != 2 {
+		t.Fatalf("expected a poller per collection of the address, got %d", n)
+	}
+	if p := pollerOf(uptime); p == nil || !reflect.DeepEqual(p.target, uptime) {
+		t.Error("expected the first target of a collection to be polled")
+	}
+
+	UpdatePolling([]connector.Targets{sensor}, nil)
+	if pollerOf(uptime) != nil || pollerOf(sensor) == nil {
+		t.Error("expected only the poller of the removed collection to be stopped")
+	}
+}
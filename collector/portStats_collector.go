@@ -1,12 +1,15 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"strconv"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 )
 
 const prefix_port = prefix + "portstats_"
@@ -38,6 +41,7 @@ var (
 func init() {
 	registerCollector("portstatsshow", defaultEnabled, NewPortErrCollector)
 	labelPortErr := append(labelnames, "portIndex")
+	reserveLabels(labelPortErr...)
 	crcErrDesc = prometheus.NewDesc(prefix_port+"crc_err", "Number of frames with CRC errors received (Rx).", labelPortErr, nil)
 	crcGEofDesc = prometheus.NewDesc(prefix_port+"crc_g_eof", "Number of frames with CRC errors with good EOF received (Rx).", labelPortErr, nil)
 	encOutDesc = prometheus.NewDesc(prefix_port+"enc_out", "Number of encoding error outside of frames received (Rx).", labelPortErr, nil)
@@ -92,17 +96,18 @@ func (*portErrCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- fbsyDesc
 	ch <- c3TimeoutTxDesc
 	ch <- c3TimeoutRxDesc
+	ch <- linkInfoDesc
 }
 
-func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+func (c *portErrCollector) Collect(ctx context.Context, client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
 
-	log.Debugln("Entering portStats collector ...")
-	portErrResp, err := client.RunCommand("porterrshow")
+	level.Debug(logger).Log("msg", "Entering portStats collector ...")
+	portErrResp, err := runCommand(ctx, client, "porterrshow")
 	if err != nil {
-		log.Errorf("Executing porterrshow command failed: %s", err)
+		level.Error(logger).Log("msg", "Executing porterrshow command failed", "err", err)
 		return err
 	}
-	log.Debugln("Response of porterrshow cmd: ", portErrResp)
 	//        frames      enc    crc    crc    too    too    bad    enc   disc   link   loss   loss   frjt   fbsy  c3timeout    pcs    uncor\n
 	//      tx     rx      in    err    g_eof  shrt   long   eof     out   c3    fail    sync   sig                  tx    rx     err    err\n
 	//  8:    0      0      0      0      0      0      0      0      0      0      0      0      0      0      0      0      0      0      0   \n
@@ -128,7 +133,7 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 		if i > 1 && len(line) > 0 {
 			// Get all metrics of a port and put them into a list
 			errPerPort := re.FindAllString(line, -1)
-			log.Debugln("errPerPort: ", errPerPort)
+			level.Debug(logger).Log("errPerPort", fmt.Sprint(errPerPort))
 			if i == 2 {
 				// Setting first port
 				firstPortIndex = errPerPort[0]
@@ -141,22 +146,22 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 
 			crc_err, err := strconv.ParseFloat(errPerPort[4], 64)
 			if err != nil {
-				log.Errorf("crc_err parsing error for %s: %s", errPerPort[4], err)
+				parseError(ctx, "crc_err parsing error for %s: %s", errPerPort[4], err)
 				return err
 			}
 			crc_g_eof, err := strconv.ParseFloat(errPerPort[5], 64)
 			if err != nil {
-				log.Errorf("crc_g_eof parsing error for %s: %s", errPerPort[5], err)
+				parseError(ctx, "crc_g_eof parsing error for %s: %s", errPerPort[5], err)
 				return err
 			}
 			enc_out, err := strconv.ParseFloat(errPerPort[9], 64)
 			if err != nil {
-				log.Errorf("enc_out parsing error for %s: %s", errPerPort[9], err)
+				parseError(ctx, "enc_out parsing error for %s: %s", errPerPort[9], err)
 				return err
 			}
 			pcs_err, err := strconv.ParseFloat(errPerPort[18], 64)
 			if err != nil {
-				log.Errorf("pcs_err parsing error for %s: %s", errPerPort[18], err)
+				parseError(ctx, "pcs_err parsing error for %s: %s", errPerPort[18], err)
 				return err
 			}
 //  on older platforms this field doesn't exist.
@@ -173,75 +178,75 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 			ch <- prometheus.MustNewConstMetric(pcsErrDesc, prometheus.GaugeValue, pcs_err, labelvalues...)
 			ch <- prometheus.MustNewConstMetric(uncorErrFECDesc, prometheus.GaugeValue, uncor_err, labelvalues...)
 
-			if *enableFullMetrics == true {
+			if fullMetrics(ctx) {
 				frames_tx, err := strconv.ParseFloat(errPerPort[1], 64)
 				if err != nil {
-					log.Errorf("frames_tx parsing error for %s: %s", errPerPort[1], err)
+					parseError(ctx, "frames_tx parsing error for %s: %s", errPerPort[1], err)
 					return err
 				}
 				frames_rx, err := strconv.ParseFloat(errPerPort[2], 64)
 				if err != nil {
-					log.Errorf("frames_rx parsing error for %s: %s", errPerPort[2], err)
+					parseError(ctx, "frames_rx parsing error for %s: %s", errPerPort[2], err)
 					return err
 				}
 				enc_in, err := strconv.ParseFloat(errPerPort[3], 64)
 				if err != nil {
-					log.Errorf("enc_in parsing error for %s: %s", errPerPort[3], err)
+					parseError(ctx, "enc_in parsing error for %s: %s", errPerPort[3], err)
 					return err
 				}
 				too_short, err := strconv.ParseFloat(errPerPort[6], 64)
 				if err != nil {
-					log.Errorf("too_short parsing error for %s: %s", errPerPort[6], err)
+					parseError(ctx, "too_short parsing error for %s: %s", errPerPort[6], err)
 					return err
 				}
 				too_long, err := strconv.ParseFloat(errPerPort[7], 64)
 				if err != nil {
-					log.Errorf("too_long parsing error for %s: %s", errPerPort[7], err)
+					parseError(ctx, "too_long parsing error for %s: %s", errPerPort[7], err)
 					return err
 				}
 				bad_eof, err := strconv.ParseFloat(errPerPort[8], 64)
 				if err != nil {
-					log.Errorf("bad_eof parsing error for %s: %s", errPerPort[8], err)
+					parseError(ctx, "bad_eof parsing error for %s: %s", errPerPort[8], err)
 					return err
 				}
 				disc_c3, err := strconv.ParseFloat(errPerPort[10], 64)
 				if err != nil {
-					log.Errorf("disc_c3 parsing error for %s: %s", errPerPort[10], err)
+					parseError(ctx, "disc_c3 parsing error for %s: %s", errPerPort[10], err)
 					return err
 				}
 				link_fail, err := strconv.ParseFloat(errPerPort[11], 64)
 				if err != nil {
-					log.Errorf("link_fail parsing error for %s: %s", errPerPort[11], err)
+					parseError(ctx, "link_fail parsing error for %s: %s", errPerPort[11], err)
 					return err
 				}
 				loss_sync, err := strconv.ParseFloat(errPerPort[12], 64)
 				if err != nil {
-					log.Errorf("loss_sync parsing error for %s: %s", errPerPort[12], err)
+					parseError(ctx, "loss_sync parsing error for %s: %s", errPerPort[12], err)
 					return err
 				}
 				loss_sig, err := strconv.ParseFloat(errPerPort[13], 64)
 				if err != nil {
-					log.Errorf("loss_sig parsing error for %s: %s", errPerPort[13], err)
+					parseError(ctx, "loss_sig parsing error for %s: %s", errPerPort[13], err)
 					return err
 				}
 				frjt, err := strconv.ParseFloat(errPerPort[14], 64)
 				if err != nil {
-					log.Errorf("frjt parsing error for %s: %s", errPerPort[14], err)
+					parseError(ctx, "frjt parsing error for %s: %s", errPerPort[14], err)
 					return err
 				}
 				fbsy, err := strconv.ParseFloat(errPerPort[15], 64)
 				if err != nil {
-					log.Errorf("fbsy parsing error for %s: %s", errPerPort[15], err)
+					parseError(ctx, "fbsy parsing error for %s: %s", errPerPort[15], err)
 					return err
 				}
 				c3_timeout_tx, err := strconv.ParseFloat(errPerPort[16], 64)
 				if err != nil {
-					log.Errorf("c3_timeout_tx parsing error for %s: %s", errPerPort[16], err)
+					parseError(ctx, "c3_timeout_tx parsing error for %s: %s", errPerPort[16], err)
 					return err
 				}
 				c3_timeout_rx, err := strconv.ParseFloat(errPerPort[17], 64)
 				if err != nil {
-					log.Errorf("c3_timpeout_rx parsing error for %s: %s", errPerPort[17], err)
+					parseError(ctx, "c3_timpeout_rx parsing error for %s: %s", errPerPort[17], err)
 					return err
 				}
 				ch <- prometheus.MustNewConstMetric(framesTxDesc, prometheus.GaugeValue, frames_tx, labelvalues...)
@@ -261,9 +266,9 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 			}
 		}
 	}
-	portStatsResp, err := client.RunCommand("portstatsshow -i " + firstPortIndex + "-" + lastPortIndex)
+	portStatsResp, err := runCommand(ctx, client, "portstatsshow -i " + firstPortIndex + "-" + lastPortIndex)
 	if err != nil {
-		log.Errorf("Executing portstatsshow command failed: %s", err)
+		level.Error(logger).Log("msg", "Executing portstatsshow command failed", "err", err)
 		return err
 	}
 	// port:  8
@@ -289,14 +294,14 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 	// Split entries by port
 	var portStats []string = regexp.MustCompile(`\n\n`).Split(portStatsResp, -1)
 	for _, portStatsPerPort := range portStats {
-		log.Debugln("portStatsPerPort: ", portStatsPerPort)
+		level.Debug(logger).Log("portStatsPerPort", fmt.Sprint(portStatsPerPort))
 		if len(portStatsPerPort) > 0 {
 			fecCorrected := regexp.MustCompile(`fec_cor_detected\s+\d+`).FindString(portStatsPerPort)
 			if fecCorrected == "" {
 				// The fec_cor_detected is replaced with fec_corrected_rate in newer version of SAN firmware
 				fecCorrected = regexp.MustCompile(`fec_corrected_rate\s+\d+`).FindString(portStatsPerPort)
 				if fecCorrected == "" {
-					log.Errorln("The fec_cor_detected/fec_corrected_rate metric not found!")
+					parseError(ctx, "The fec_cor_detected/fec_corrected_rate metric not found!")
 					return nil
 				}
 			}
@@ -304,12 +309,12 @@ func (c *portErrCollector) Collect(client *connector.SSHConnection, ch chan<- pr
 			labelvalues := append(labelvalue, portIndex)
 			fecCorrectedValue, err := strconv.ParseFloat(regexp.MustCompile(`\d+`).FindString(fecCorrected), 64)
 			if err != nil {
-				log.Errorf("fec_cor_detected/fec_corrected_rate parsing error for %s: %s", portStatsPerPort, err)
+				parseError(ctx, "fec_cor_detected/fec_corrected_rate parsing error for %s: %s", portStatsPerPort, err)
 				return err
 			}
 			ch <- prometheus.MustNewConstMetric(corFECDesc, prometheus.GaugeValue, fecCorrectedValue, labelvalues...)
 		}
 	}
-	log.Debugln("Leaving portStats collector.")
+	level.Debug(logger).Log("msg", "Leaving portStats collector.")
 	return nil
 }
@@ -1,12 +1,15 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"strconv"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 )
 
 const prefix_sensor = prefix + "sensor_"
@@ -31,6 +34,7 @@ func init() {
 	labelTemper := append(labelnames, "status", "sensorID")
 	labelPower := append(labelnames, "status", "powerID")
 	labelFan := append(labelnames, "status", "fanID")
+	reserveLabels("status", "sensorID", "powerID", "fanID")
 	temperatureDesc = prometheus.NewDesc(prefix_sensor+"temperature_centigrade", "Displays the current temperature, the unit is Centigrade", labelTemper, nil)
 	powerSupplyDesc = prometheus.NewDesc(prefix_sensor+"power_supplies", "Status of power supplies.", labelPower, nil)
 	fanDesc = prometheus.NewDesc(prefix_sensor+"fan_speed", "Speed of fan, the unit is RPM.", labelFan, nil)
@@ -50,14 +54,14 @@ func (*sensorCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- fanDesc
 }
 
-func (c *sensorCollector) Collect(client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
-	log.Debugln("Entering sensor collector ...")
-	sensorResp, err := client.RunCommand("sensorshow")
+func (c *sensorCollector) Collect(ctx context.Context, client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
+	level.Debug(logger).Log("msg", "Entering sensor collector ...")
+	sensorResp, err := runCommand(ctx, client, "sensorshow")
 	if err != nil {
-		log.Errorf("Executing sensorshow command failed: %s", err)
+		level.Error(logger).Log("msg", "Executing sensorshow command failed", "err", err)
 		return err
 	}
-	log.Debugln("Response of sensorshow cmd: ", sensorResp)
 	// sensor  1: (Temperature) is Ok, value is 39 C
 	// sensor  2: (Fan        ) is Ok,speed is 8653 RPM
 	// sensor  3: (Fan        ) is Ok,speed is 8653 RPM
@@ -71,7 +75,7 @@ func (c *sensorCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 	for _, line := range sensorRespSplit {
 		if len(line) > 0 {
 			sensorMetric := re.FindAllString(line, -1)
-			log.Debugln("sensorMetric: ", sensorMetric)
+			level.Debug(logger).Log("sensorMetric", fmt.Sprint(sensorMetric))
 			switch sensorMetric[1] {
 			case "Temperature":
 				// [1 Temperature Ok 39]
@@ -81,7 +85,7 @@ func (c *sensorCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 					} else {
 						temperature, err = strconv.ParseFloat(sensorMetric[3], 64)
 						if err != nil {
-							log.Errorf("temperature parsing error for %s: %s", sensorMetric[2], err)
+							parseError(ctx, "temperature parsing error for %s: %s", sensorMetric[2], err)
 							return err
 						}
 					}
@@ -98,7 +102,7 @@ func (c *sensorCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 					} else {
 						fanSpeed, err = strconv.ParseFloat(sensorMetric[3], 64)
 						if err != nil {
-							log.Errorf("fanSpeed parsing error for %s: %s", sensorMetric[3], err)
+							parseError(ctx, "fanSpeed parsing error for %s: %s", sensorMetric[3], err)
 							return err
 						}
 					}
@@ -114,6 +118,6 @@ func (c *sensorCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 			}
 		}
 	}
-	log.Debugln("Leaving sensor collector.")
+	level.Debug(logger).Log("msg", "Leaving sensor collector.")
 	return err
 }
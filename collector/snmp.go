@@ -0,0 +1,404 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// OIDs of the objects read when a target is collected over SNMP.
+const (
+	// SNMPv2-MIB
+	oidSysUpTime = "1.3.6.1.2.1.1.3.0"
+	oidSysName   = "1.3.6.1.2.1.1.5.0"
+
+	// SW-MIB
+	oidSwFirmwareVersion = "1.3.6.1.4.1.1588.2.1.1.1.1.6.0"
+	oidSwSensorEntry     = "1.3.6.1.4.1.1588.2.1.1.1.1.22.1"
+	oidSwFCPortEntry     = "1.3.6.1.4.1.1588.2.1.1.1.6.2.1"
+
+	// FCMGMT-MIB (Fibre Alliance MIB, FA-MIB)
+	oidConnUnitEntry         = "1.3.6.1.3.94.1.6.1"
+	oidConnUnitLinkEntry     = "1.3.6.1.3.94.1.12.1"
+	oidConnUnitPortStatEntry = "1.3.6.1.3.94.4.5.1"
+)
+
+// Columns of the swSensorTable
+const (
+	swSensorType   = 2
+	swSensorStatus = 3
+	swSensorValue  = 4
+)
+
+// Columns of the swFCPortTable
+const (
+	swFCPortTxFrames    = 13
+	swFCPortRxFrames    = 14
+	swFCPortRxEncInFrs  = 21
+	swFCPortRxCrcs      = 22
+	swFCPortRxTruncs    = 23
+	swFCPortRxTooLongs  = 24
+	swFCPortRxBadEofs   = 25
+	swFCPortRxEncOutFrs = 26
+	swFCPortC3Discards  = 28
+)
+
+// Columns of the connUnitTable
+const (
+	connUnitId = 1
+	// connUnitIdSize is the size of the connUnitId, which starts the index of the FCMGMT-MIB tables
+	connUnitIdSize = 16
+)
+
+// Columns of the connUnitPortStatTable
+const (
+	connUnitPortStatCountFBSYFrames            = 10
+	connUnitPortStatCountFRJTFrames            = 12
+	connUnitPortStatCountLinkFailures          = 39
+	connUnitPortStatCountLossofSignal          = 43
+	connUnitPortStatCountLossofSynchronization = 44
+)
+
+// Columns of the connUnitLinkTable
+const (
+	connUnitLinkPortNumberX = 4
+	connUnitLinkNodeIdY     = 6
+	connUnitLinkPortNumberY = 7
+	connUnitLinkPortWwnY    = 8
+)
+
+var (
+	linkInfoDesc *prometheus.Desc
+	// swSensorType values of the SW-MIB
+	snmpSensorTypes = map[int64]string{
+		1: "Temperature",
+		2: "Fan",
+		3: "Power Supply",
+	}
+	// swSensorStatus values of the SW-MIB mapped onto the sensorshow status strings
+	snmpSensorStatus = map[int64]string{
+		1: "Unknown",
+		2: "Faulty",
+		3: "Predicting failure",
+		4: "Ok",
+		5: "Predicting failure",
+		6: "Absent",
+	}
+)
+
+func init() {
+	labelLink := append(labelnames, "portIndex", "remoteNodeWwn", "remotePortWwn", "remotePortIndex")
+	reserveLabels(labelLink...)
+	linkInfoDesc = prometheus.NewDesc(prefix+"snmp_link_info", "Link between a local port and a remote port, as reported by the connUnitLinkTable (SNMP only).", labelLink, nil)
+}
+
+// SNMPCollector is implemented by collectors which can also collect their metrics over SNMP
+type SNMPCollector interface {
+	//CollectSNMP collects metrics from the SNMP agent of the device
+	CollectSNMP(ctx context.Context, client *connector.SNMPConnection, ch chan<- prometheus.Metric, labelvalue []string) error
+}
+
+// snmpRow holds the columns of one table row, keyed by column number
+type snmpRow map[int]gosnmp.SnmpPDU
+
+// snmpTable walks the given columns of a table and returns its rows keyed by the row index.
+// The indexes are returned in walk order.
+func snmpTable(client *connector.SNMPConnection, entryOID string, columns ...int) (map[string]snmpRow, []string, error) {
+	rows := make(map[string]snmpRow)
+	var indexes []string
+	for _, column := range columns {
+		columnOID := entryOID + "." + strconv.Itoa(column)
+		pdus, err := client.Walk(columnOID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pdu := range pdus {
+			index := strings.TrimPrefix(strings.TrimPrefix(pdu.Name, "."), columnOID+".")
+			row, found := rows[index]
+			if !found {
+				row = make(snmpRow)
+				rows[index] = row
+				indexes = append(indexes, index)
+			}
+			row[column] = pdu
+		}
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return lastSubID(indexes[i]) < lastSubID(indexes[j])
+	})
+	return rows, indexes, nil
+}
+
+// lastSubID returns the last sub-identifier of a table index, which is the port or sensor number
+func lastSubID(index string) int64 {
+	id, _ := strconv.ParseInt(index[strings.LastIndex(index, ".")+1:], 10, 64)
+	return id
+}
+
+// snmpConnUnit returns the connUnitId part of the index of a FCMGMT-MIB table row. The connUnitId
+// has a fixed size of 16 bytes, so its sub-identifiers aren't preceded by the length.
+func snmpConnUnit(index string) string {
+	ids := strings.Split(index, ".")
+	if len(ids) <= connUnitIdSize {
+		return ""
+	}
+	return strings.Join(ids[:connUnitIdSize], ".")
+}
+
+// snmpSwitchConnUnit returns the connUnitId of the switch as index sub-identifiers. Fabric OS only lists
+// the switch in the connUnitTable, of the units of other agents the first is taken. It is empty if the table is.
+func snmpSwitchConnUnit(client *connector.SNMPConnection) (string, error) {
+	columnOID := oidConnUnitEntry + "." + strconv.Itoa(connUnitId)
+	pdus, err := client.Walk(columnOID)
+	if err != nil || len(pdus) == 0 {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(pdus[0].Name, "."), columnOID+"."), nil
+}
+
+// localConnUnitRows returns the indexes of the rows of a FCMGMT-MIB table which belong to the connUnit of
+// the switch, all of them if it is unknown. The ports of other connUnits would collide with its own.
+func localConnUnitRows(ctx context.Context, unit string, indexes []string) []string {
+	if unit == "" {
+		return indexes
+	}
+	var local []string
+	for _, index := range indexes {
+		if snmpConnUnit(index) == unit {
+			local = append(local, index)
+		}
+	}
+	if skipped := len(indexes) - len(local); skipped > 0 {
+		level.Debug(logging.FromContext(ctx)).Log("msg", "Skipped the rows of other connUnits", "connUnit", unit, "rows", skipped)
+	}
+	return local
+}
+
+// snmpPortIndex converts the 1-based port index used in the MIB tables into the FOS port number
+func snmpPortIndex(index string) string {
+	return strconv.FormatInt(lastSubID(index)-1, 10)
+}
+
+// snmpFloat converts an SNMP value into a float. The FCMGMT-MIB encodes its
+// 64 bit counters as 8 byte octet strings, these are decoded as big endian numbers.
+func snmpFloat(pdu gosnmp.SnmpPDU) (float64, error) {
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(pdu.Value)).Float64()
+		return f, nil
+	case gosnmp.OctetString:
+		f, _ := new(big.Float).SetInt(new(big.Int).SetBytes(pdu.Value.([]byte))).Float64()
+		return f, nil
+	}
+	return 0, fmt.Errorf("unexpected type %s of %s", pdu.Type, pdu.Name)
+}
+
+// snmpString converts an SNMP value into a string
+func snmpString(pdu gosnmp.SnmpPDU) string {
+	switch v := pdu.Value.(type) {
+	case []byte:
+		return strings.TrimRight(string(v), "\x00")
+	case string:
+		return v
+	}
+	return fmt.Sprint(pdu.Value)
+}
+
+// snmpHex formats an octet string such as a WWN as colon separated hex bytes
+func snmpHex(pdu gosnmp.SnmpPDU) string {
+	b, ok := pdu.Value.([]byte)
+	if !ok {
+		return ""
+	}
+	parts := make([]string, len(b))
+	for i, octet := range b {
+		parts[i] = fmt.Sprintf("%02x", octet)
+	}
+	return strings.Join(parts, ":")
+}
+
+// snmpSysName returns the sysName of the device, which is its switch name
+func snmpSysName(client *connector.SNMPConnection) (string, error) {
+	pdus, err := client.Get(oidSysName)
+	if err != nil {
+		return "", err
+	}
+	if len(pdus) == 0 {
+		return "", nil
+	}
+	return snmpString(pdus[0]), nil
+}
+
+// CollectSNMP maps sysUpTime and swFirmwareVersion onto the uptime metric
+func (c *uptimeCollector) CollectSNMP(ctx context.Context, client *connector.SNMPConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
+	level.Debug(logger).Log("msg", "Entering uptime SNMP collector ...")
+	pdus, err := client.Get(oidSysUpTime, oidSwFirmwareVersion)
+	if err != nil {
+		return err
+	}
+	var uptimeInSecs float64
+	var version string
+	for _, pdu := range pdus {
+		switch strings.TrimPrefix(pdu.Name, ".") {
+		case oidSysUpTime:
+			ticks, err := snmpFloat(pdu)
+			if err != nil {
+				return err
+			}
+			// sysUpTime is measured in hundredths of a second
+			uptimeInSecs = ticks / 100
+		case oidSwFirmwareVersion:
+			version = snmpString(pdu)
+		}
+	}
+	setVersion(ctx, version)
+	labelValueUptime := append(labelvalue, version)
+	ch <- prometheus.MustNewConstMetric(uptimeDesc, prometheus.GaugeValue, uptimeInSecs, labelValueUptime...)
+	level.Debug(logger).Log("msg", "Leaving uptime SNMP collector.")
+	return nil
+}
+
+// CollectSNMP maps the swSensorTable onto the sensor metrics
+func (c *sensorCollector) CollectSNMP(ctx context.Context, client *connector.SNMPConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
+	level.Debug(logger).Log("msg", "Entering sensor SNMP collector ...")
+	rows, indexes, err := snmpTable(client, oidSwSensorEntry, swSensorType, swSensorStatus, swSensorValue)
+	if err != nil {
+		return err
+	}
+	countTemper := 0
+	countFan := 0
+	countPower := 0
+	for _, index := range indexes {
+		row := rows[index]
+		sensorType, err := snmpFloat(row[swSensorType])
+		if err != nil {
+			return err
+		}
+		statusValue, err := snmpFloat(row[swSensorStatus])
+		if err != nil {
+			return err
+		}
+		status := snmpSensorStatus[int64(statusValue)]
+		value, err := snmpFloat(row[swSensorValue])
+		if err != nil {
+			value = 0
+		}
+		switch snmpSensorTypes[int64(sensorType)] {
+		case "Temperature":
+			countTemper += 1
+			labelvalues := append(labelvalue, status, strconv.Itoa(countTemper))
+			ch <- prometheus.MustNewConstMetric(temperatureDesc, prometheus.GaugeValue, value, labelvalues...)
+		case "Fan":
+			countFan += 1
+			labelvalues := append(labelvalue, status, strconv.Itoa(countFan))
+			ch <- prometheus.MustNewConstMetric(fanDesc, prometheus.GaugeValue, value, labelvalues...)
+		case "Power Supply":
+			countPower += 1
+			labelvalues := append(labelvalue, status, strconv.Itoa(countPower))
+			ch <- prometheus.MustNewConstMetric(powerSupplyDesc, prometheus.GaugeValue, float64(statusValues[status]), labelvalues...)
+		}
+	}
+	level.Debug(logger).Log("msg", "Leaving sensor SNMP collector.")
+	return nil
+}
+
+// CollectSNMP maps the swFCPortTable and connUnitPortStatTable onto the port metrics
+// and exports the connUnitLinkTable as link info metrics
+func (c *portErrCollector) CollectSNMP(ctx context.Context, client *connector.SNMPConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
+	level.Debug(logger).Log("msg", "Entering portStats SNMP collector ...")
+	swColumns := map[int]*prometheus.Desc{
+		swFCPortRxCrcs:      crcErrDesc,
+		swFCPortRxEncOutFrs: encOutDesc,
+	}
+	faColumns := map[int]*prometheus.Desc{}
+	if fullMetrics(ctx) {
+		swColumns[swFCPortTxFrames] = framesTxDesc
+		swColumns[swFCPortRxFrames] = framesRxDesc
+		swColumns[swFCPortRxEncInFrs] = encInDesc
+		swColumns[swFCPortRxTruncs] = tooShortDesc
+		swColumns[swFCPortRxTooLongs] = tooLongDesc
+		swColumns[swFCPortRxBadEofs] = badEofDesc
+		swColumns[swFCPortC3Discards] = discC3Desc
+		faColumns[connUnitPortStatCountLinkFailures] = linkFailDesc
+		faColumns[connUnitPortStatCountLossofSynchronization] = lossSyncDesc
+		faColumns[connUnitPortStatCountLossofSignal] = lossSigDesc
+		faColumns[connUnitPortStatCountFRJTFrames] = frjtDesc
+		faColumns[connUnitPortStatCountFBSYFrames] = fbsyDesc
+	}
+	unit, err := snmpSwitchConnUnit(client)
+	if err != nil {
+		return err
+	}
+	if err := collectSNMPPortTable(ctx, client, ch, labelvalue, oidSwFCPortEntry, swColumns, ""); err != nil {
+		return err
+	}
+	if err := collectSNMPPortTable(ctx, client, ch, labelvalue, oidConnUnitPortStatEntry, faColumns, unit); err != nil {
+		return err
+	}
+
+	rows, indexes, err := snmpTable(client, oidConnUnitLinkEntry, connUnitLinkPortNumberX, connUnitLinkNodeIdY, connUnitLinkPortNumberY, connUnitLinkPortWwnY)
+	if err != nil {
+		return err
+	}
+	for _, index := range localConnUnitRows(ctx, unit, indexes) {
+		row := rows[index]
+		localPort, err := snmpFloat(row[connUnitLinkPortNumberX])
+		if err != nil {
+			return err
+		}
+		remotePort, err := snmpFloat(row[connUnitLinkPortNumberY])
+		if err != nil {
+			return err
+		}
+		labelvalues := append(labelvalue,
+			strconv.Itoa(int(localPort)-1),
+			snmpHex(row[connUnitLinkNodeIdY]),
+			snmpHex(row[connUnitLinkPortWwnY]),
+			strconv.Itoa(int(remotePort)-1))
+		ch <- prometheus.MustNewConstMetric(linkInfoDesc, prometheus.GaugeValue, 1, labelvalues...)
+	}
+	level.Debug(logger).Log("msg", "Leaving portStats SNMP collector.")
+	return nil
+}
+
+// collectSNMPPortTable exports the given columns of a per port table using the mapped metric descriptions.
+// The rows of a table indexed by connUnit are limited to those of the connUnit, unless it is empty.
+func collectSNMPPortTable(ctx context.Context, client *connector.SNMPConnection, ch chan<- prometheus.Metric, labelvalue []string, entryOID string, columns map[int]*prometheus.Desc, connUnit string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	var columnIDs []int
+	for column := range columns {
+		columnIDs = append(columnIDs, column)
+	}
+	sort.Ints(columnIDs)
+	rows, indexes, err := snmpTable(client, entryOID, columnIDs...)
+	if err != nil {
+		return err
+	}
+	indexes = localConnUnitRows(ctx, connUnit, indexes)
+	for _, index := range indexes {
+		labelvalues := append(labelvalue, snmpPortIndex(index))
+		for column, pdu := range rows[index] {
+			value, err := snmpFloat(pdu)
+			if err != nil {
+				parseError(ctx, "parsing error for %s: %s", pdu.Name, err)
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(columns[column], prometheus.GaugeValue, value, labelvalues...)
+		}
+	}
+	return nil
+}
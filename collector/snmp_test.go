@@ -0,0 +1,431 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+// snmpAgent is a stand-in for the SNMP agent of a switch. It answers SNMPv2c get, getnext and
+// getbulk requests from a walk recorded in the snmprec format of snmpsim.
+type snmpAgent struct {
+	conn *net.UDPConn
+	pdus []gosnmp.SnmpPDU
+
+	mu sync.Mutex
+	// requests is the number of requests answered
+	requests int
+	// delay is waited before each answer
+	delay time.Duration
+}
+
+// newSNMPAgent serves the recorded walk on a random local UDP port
+func newSNMPAgent(t *testing.T, snmprec string) *snmpAgent {
+	t.Helper()
+	pdus, err := readSnmprec(snmprec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &snmpAgent{conn: conn, pdus: pdus}
+	go a.serve()
+	return a
+}
+
+func (a *snmpAgent) close() {
+	a.conn.Close()
+}
+
+func (a *snmpAgent) setDelay(delay time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delay = delay
+}
+
+func (a *snmpAgent) requestCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests
+}
+
+func (a *snmpAgent) port() uint16 {
+	return uint16(a.conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// target returns a target collected over SNMP from the agent
+func (a *snmpAgent) target() connector.Targets {
+	return connector.Targets{
+		IpAddress: "127.0.0.1",
+		Transport: connector.TransportSNMP,
+		SNMP:      connector.SNMPConfig{Port: a.port(), Version: "2c", Community: "public"},
+	}
+}
+
+func (a *snmpAgent) serve() {
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c, Community: "public"}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		a.mu.Lock()
+		a.requests++
+		delay := a.delay
+		a.mu.Unlock()
+		time.Sleep(delay)
+		response := &gosnmp.SnmpPacket{
+			Version:   request.Version,
+			Community: request.Community,
+			PDUType:   gosnmp.GetResponse,
+			RequestID: request.RequestID,
+			Variables: a.answer(request),
+		}
+		b, err := response.MarshalMsg()
+		if err != nil {
+			continue
+		}
+		a.conn.WriteToUDP(b, addr)
+	}
+}
+
+func (a *snmpAgent) answer(request *gosnmp.SnmpPacket) []gosnmp.SnmpPDU {
+	var variables []gosnmp.SnmpPDU
+	switch request.PDUType {
+	case gosnmp.GetRequest:
+		for _, v := range request.Variables {
+			variables = append(variables, a.get(v.Name))
+		}
+	case gosnmp.GetNextRequest:
+		for _, v := range request.Variables {
+			variables = append(variables, a.next(v.Name))
+		}
+	case gosnmp.GetBulkRequest:
+		// gosnmp doesn't decode max-repetitions of requests, the exporter asks for 25
+		repetitions := request.MaxRepetitions
+		if repetitions == 0 {
+			repetitions = 25
+		}
+		for _, v := range request.Variables {
+			name := v.Name
+			for i := uint32(0); i < repetitions; i++ {
+				pdu := a.next(name)
+				variables = append(variables, pdu)
+				if pdu.Type == gosnmp.EndOfMibView {
+					break
+				}
+				name = pdu.Name
+			}
+		}
+	}
+	return variables
+}
+
+func (a *snmpAgent) get(name string) gosnmp.SnmpPDU {
+	for _, pdu := range a.pdus {
+		if compareOIDs(pdu.Name, name) == 0 {
+			return pdu
+		}
+	}
+	return gosnmp.SnmpPDU{Name: name, Type: gosnmp.NoSuchObject}
+}
+
+func (a *snmpAgent) next(name string) gosnmp.SnmpPDU {
+	for _, pdu := range a.pdus {
+		if compareOIDs(pdu.Name, name) > 0 {
+			return pdu
+		}
+	}
+	return gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}
+}
+
+// compareOIDs compares two OIDs sub-identifier by sub-identifier
+func compareOIDs(a, b string) int {
+	x := strings.Split(strings.TrimPrefix(a, "."), ".")
+	y := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, _ := strconv.ParseUint(x[i], 10, 32)
+		n, _ := strconv.ParseUint(y[i], 10, 32)
+		if m != n {
+			if m < n {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(x) - len(y)
+}
+
+// readSnmprec reads a walk in the snmprec format: oid|type|value, with the types of the BER tags
+func readSnmprec(filename string) ([]gosnmp.SnmpPDU, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pdus []gosnmp.SnmpPDU
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "|", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		pdu := gosnmp.SnmpPDU{Name: "." + fields[0]}
+		switch fields[1] {
+		case "2":
+			pdu.Type = gosnmp.Integer
+			pdu.Value, err = strconv.Atoi(fields[2])
+		case "4":
+			pdu.Type = gosnmp.OctetString
+			pdu.Value = []byte(fields[2])
+		case "4x":
+			pdu.Type = gosnmp.OctetString
+			pdu.Value, err = hex.DecodeString(fields[2])
+		case "65", "66", "67":
+			pdu.Type = map[string]gosnmp.Asn1BER{"65": gosnmp.Counter32, "66": gosnmp.Gauge32, "67": gosnmp.TimeTicks}[fields[1]]
+			var n uint64
+			n, err = strconv.ParseUint(fields[2], 10, 32)
+			pdu.Value = uint32(n)
+		case "70":
+			pdu.Type = gosnmp.Counter64
+			pdu.Value, err = strconv.ParseUint(fields[2], 10, 64)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pdus = append(pdus, pdu)
+	}
+	sort.Slice(pdus, func(i, j int) bool { return compareOIDs(pdus[i].Name, pdus[j].Name) < 0 })
+	return pdus, scanner.Err()
+}
+
+// snmpCollector runs the SNMP collection of a collector for the test registry
+type snmpCollector struct {
+	ctx    context.Context
+	client *connector.SNMPConnection
+	c      SNMPCollector
+	err    error
+}
+
+func (s *snmpCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (s *snmpCollector) Collect(ch chan<- prometheus.Metric) {
+	s.err = s.c.CollectSNMP(s.ctx, s.client, ch, []string{"127.0.0.1", "SAN1"})
+}
+
+func TestCollectSNMP(t *testing.T) {
+	agent := newSNMPAgent(t, "testdata/snmp/fos.snmprec")
+	defer agent.close()
+	fullMetrics := true
+	target := agent.target()
+	target.FullMetrics = &fullMetrics
+	ctx := targetContext(context.Background(), target)
+
+	client, err := connector.NewSNMPConnection(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	hostname, err := snmpSysName(client)
+	if err != nil || hostname != "SAN1" {
+		t.Fatalf("snmpSysName() = %q, %v, want SAN1", hostname, err)
+	}
+
+	tests := []struct {
+		name      string
+		collector SNMPCollector
+		metrics   []string
+		expected  string
+	}{
+		{
+			name:      "uptime",
+			collector: &uptimeCollector{},
+			metrics:   []string{"fabricos_uptime"},
+			expected: `
+# HELP fabricos_uptime Displays how long the system has been running
+# TYPE fabricos_uptime gauge
+fabricos_uptime{resource="SAN1",target="127.0.0.1",version="v9.1.1a"} 1234.56
+`,
+		},
+		{
+			name:      "swSensorTable",
+			collector: &sensorCollector{},
+			metrics:   []string{"fabricos_sensor_temperature_centigrade", "fabricos_sensor_fan_speed", "fabricos_sensor_power_supplies"},
+			expected: `
+# HELP fabricos_sensor_fan_speed Speed of fan, the unit is RPM.
+# TYPE fabricos_sensor_fan_speed gauge
+fabricos_sensor_fan_speed{fanID="1",resource="SAN1",status="Ok",target="127.0.0.1"} 7500
+fabricos_sensor_fan_speed{fanID="2",resource="SAN1",status="Faulty",target="127.0.0.1"} 0
+# HELP fabricos_sensor_power_supplies Status of power supplies.
+# TYPE fabricos_sensor_power_supplies gauge
+fabricos_sensor_power_supplies{powerID="1",resource="SAN1",status="Ok",target="127.0.0.1"} 1
+# HELP fabricos_sensor_temperature_centigrade Displays the current temperature, the unit is Centigrade
+# TYPE fabricos_sensor_temperature_centigrade gauge
+fabricos_sensor_temperature_centigrade{resource="SAN1",sensorID="1",status="Ok",target="127.0.0.1"} 38
+fabricos_sensor_temperature_centigrade{resource="SAN1",sensorID="2",status="Ok",target="127.0.0.1"} 41
+`,
+		},
+		{
+			name:      "swFCPortTable",
+			collector: &portErrCollector{},
+			metrics:   []string{"fabricos_portstats_crc_err", "fabricos_portstats_enc_out", "fabricos_portstats_frames_tx", "fabricos_portstats_too_long", "fabricos_portstats_disc_c3"},
+			expected: `
+# HELP fabricos_portstats_crc_err Number of frames with CRC errors received (Rx).
+# TYPE fabricos_portstats_crc_err gauge
+fabricos_portstats_crc_err{portIndex="0",resource="SAN1",target="127.0.0.1"} 12
+fabricos_portstats_crc_err{portIndex="1",resource="SAN1",target="127.0.0.1"} 0
+# HELP fabricos_portstats_disc_c3 Number of Class 3 frames discarded (Rx).
+# TYPE fabricos_portstats_disc_c3 gauge
+fabricos_portstats_disc_c3{portIndex="0",resource="SAN1",target="127.0.0.1"} 8
+fabricos_portstats_disc_c3{portIndex="1",resource="SAN1",target="127.0.0.1"} 0
+# HELP fabricos_portstats_enc_out Number of encoding error outside of frames received (Rx).
+# TYPE fabricos_portstats_enc_out gauge
+fabricos_portstats_enc_out{portIndex="0",resource="SAN1",target="127.0.0.1"} 96
+fabricos_portstats_enc_out{portIndex="1",resource="SAN1",target="127.0.0.1"} 5
+# HELP fabricos_portstats_frames_tx Number of frames transmitted errors (Tx).
+# TYPE fabricos_portstats_frames_tx gauge
+fabricos_portstats_frames_tx{portIndex="0",resource="SAN1",target="127.0.0.1"} 3e+06
+fabricos_portstats_frames_tx{portIndex="1",resource="SAN1",target="127.0.0.1"} 17
+# HELP fabricos_portstats_too_long Number of frames longer than maximum received (Rx).
+# TYPE fabricos_portstats_too_long gauge
+fabricos_portstats_too_long{portIndex="0",resource="SAN1",target="127.0.0.1"} 0
+fabricos_portstats_too_long{portIndex="1",resource="SAN1",target="127.0.0.1"} 3
+`,
+		},
+		{
+			name:      "connUnitPortStatTable",
+			collector: &portErrCollector{},
+			metrics:   []string{"fabricos_portstats_link_fail", "fabricos_portstats_loss_sig", "fabricos_portstats_loss_sync", "fabricos_portstats_frjt", "fabricos_portstats_fbsy"},
+			expected: `
+# HELP fabricos_portstats_fbsy Number of transmitted frames busied with F_BSY (Tx).
+# TYPE fabricos_portstats_fbsy gauge
+fabricos_portstats_fbsy{portIndex="0",resource="SAN1",target="127.0.0.1"} 0
+fabricos_portstats_fbsy{portIndex="1",resource="SAN1",target="127.0.0.1"} 7
+# HELP fabricos_portstats_frjt Number of transmitted frames rejected with F_RJT (Tx).
+# TYPE fabricos_portstats_frjt gauge
+fabricos_portstats_frjt{portIndex="0",resource="SAN1",target="127.0.0.1"} 3
+fabricos_portstats_frjt{portIndex="1",resource="SAN1",target="127.0.0.1"} 0
+# HELP fabricos_portstats_link_fail Number of link failures (LF1 or LF2 states) received (Rx).
+# TYPE fabricos_portstats_link_fail gauge
+fabricos_portstats_link_fail{portIndex="0",resource="SAN1",target="127.0.0.1"} 2
+fabricos_portstats_link_fail{portIndex="1",resource="SAN1",target="127.0.0.1"} 4.294967296e+09
+# HELP fabricos_portstats_loss_sig Number of times a loss of signal was received (increments whenever an SFP is removed) (Rx).
+# TYPE fabricos_portstats_loss_sig gauge
+fabricos_portstats_loss_sig{portIndex="0",resource="SAN1",target="127.0.0.1"} 4
+fabricos_portstats_loss_sig{portIndex="1",resource="SAN1",target="127.0.0.1"} 17
+# HELP fabricos_portstats_loss_sync Number of times synchronization was lost (Rx).
+# TYPE fabricos_portstats_loss_sync gauge
+fabricos_portstats_loss_sync{portIndex="0",resource="SAN1",target="127.0.0.1"} 6
+fabricos_portstats_loss_sync{portIndex="1",resource="SAN1",target="127.0.0.1"} 0
+`,
+		},
+		{
+			name:      "connUnitLinkTable",
+			collector: &portErrCollector{},
+			metrics:   []string{"fabricos_snmp_link_info"},
+			expected: `
+# HELP fabricos_snmp_link_info Link between a local port and a remote port, as reported by the connUnitLinkTable (SNMP only).
+# TYPE fabricos_snmp_link_info gauge
+fabricos_snmp_link_info{portIndex="1",remoteNodeWwn="10:00:00:05:1e:0c:1d:3a",remotePortIndex="4",remotePortWwn="20:05:00:05:1e:0c:1d:3a",resource="SAN1",target="127.0.0.1"} 1
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &snmpCollector{ctx: ctx, client: client, c: test.collector}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(test.expected), test.metrics...); err != nil {
+				t.Error(err)
+			}
+			if c.err != nil {
+				t.Errorf("CollectSNMP() failed: %s", c.err)
+			}
+		})
+	}
+}
+
+func TestSNMPFloat(t *testing.T) {
+	tests := []struct {
+		pdu      gosnmp.SnmpPDU
+		expected float64
+	}{
+		{gosnmp.SnmpPDU{Type: gosnmp.Counter32, Value: uint(4294967295)}, 4294967295},
+		{gosnmp.SnmpPDU{Type: gosnmp.Counter64, Value: uint64(1) << 40}, 1 << 40},
+		{gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: -3}, -3},
+		{gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0, 0, 0, 1, 0, 0, 0, 0}}, 4294967296},
+		{gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}, 9223372036854775807},
+	}
+	for _, test := range tests {
+		value, err := snmpFloat(test.pdu)
+		if err != nil || value != test.expected {
+			t.Errorf("snmpFloat(%v) = %v, %v, want %v", test.pdu.Value, value, err, test.expected)
+		}
+	}
+	if _, err := snmpFloat(gosnmp.SnmpPDU{Type: gosnmp.IPAddress, Value: "10.0.0.1"}); err == nil {
+		t.Error("snmpFloat() of an IP address succeeded")
+	}
+}
+
+func TestSNMPPortIndex(t *testing.T) {
+	tests := map[string]string{
+		"1":  "0",
+		"48": "47",
+		"16.0.136.148.113.97.93.115.0.0.0.0.0.0.0.0.1": "0",
+		"16.0.136.148.113.97.93.115.0.0.0.0.0.0.0.0.2": "1",
+	}
+	for index, expected := range tests {
+		if got := snmpPortIndex(index); got != expected {
+			t.Errorf("snmpPortIndex(%q) = %q, want %q", index, got, expected)
+		}
+	}
+}
+
+func TestLocalConnUnitRows(t *testing.T) {
+	const (
+		local = "16.0.136.148.113.97.93.115.0.0.0.0.0.0.0.0"
+		other = "16.0.0.5.30.12.29.58.0.0.0.0.0.0.0.0"
+	)
+	if unit := snmpConnUnit(local + ".2"); unit != local {
+		t.Errorf("snmpConnUnit() = %q, want %q", unit, local)
+	}
+	if unit := snmpConnUnit("2"); unit != "" {
+		t.Errorf("expected no connUnit in the index of a SW-MIB table, got %q", unit)
+	}
+
+	indexes := []string{other + ".1", local + ".1", local + ".2"}
+	if rows := localConnUnitRows(context.Background(), local, indexes); !reflect.DeepEqual(rows, indexes[1:]) {
+		t.Errorf("expected the rows of the switch, got %v", rows)
+	}
+	if rows := localConnUnitRows(context.Background(), "", indexes); !reflect.DeepEqual(rows, indexes) {
+		t.Errorf("expected all rows without connUnitTable, got %v", rows)
+	}
+}
+
+func TestSNMPCollectorNames(t *testing.T) {
+	names := append([]string(nil), connector.SNMPCollectorNames...)
+	sort.Strings(names)
+	if expected := []string{"portstatsshow", "sensorshow", "uptime"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("SNMPCollectorNames = %v, want %v", names, expected)
+	}
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSwitch is a stand-in for the SSH server of a switch, answering exec requests with canned outputs.
+// Unknown commands fail with exit status 1.
+type sshSwitch struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	outputs  map[string]string
+	dir      string
+}
+
+func newSSHSwitch(t *testing.T, outputs map[string]string) *sshSwitch {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sshSwitch{listener: l, config: config, outputs: outputs, dir: dir}
+	go s.serve()
+	return s
+}
+
+func (s *sshSwitch) close() {
+	s.listener.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *sshSwitch) target() connector.Targets {
+	return connector.Targets{
+		IpAddress:   s.listener.Addr().String(),
+		Transport:   connector.TransportSSH,
+		CommandMode: connector.CommandModeExec,
+		Credentials: connector.Credentials{Userid: "admin", Password: "secret"},
+	}
+}
+
+// manager returns a connection manager which trusts the switch on first use
+func (s *sshSwitch) manager(t *testing.T) *connector.SSHConnectionManager {
+	m, err := connector.NewConnectionManager(connector.WithHostKeyStore(connector.NewHostKeyStore(filepath.Join(s.dir, "known_hosts"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (s *sshSwitch) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *sshSwitch) handle(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(ch, creqs)
+	}
+}
+
+func (s *sshSwitch) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+		status := uint32(0)
+		output, found := s.outputs[payload.Command]
+		if !found {
+			status = 1
+		}
+		io.WriteString(ch, output)
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		ch.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectorStatus is the result of the last run of a collector for a target
+type CollectorStatus struct {
+	Name     string
+	LastRun  time.Time
+	Success  bool
+	TimedOut bool
+	Duration time.Duration
+	Error    string
+}
+
+// TargetStatus is the result of the last scrape of a target
+type TargetStatus struct {
+	Target     string
+	LastScrape time.Time
+	Duration   time.Duration
+	Success    bool
+	Error      string
+	SwitchName string
+	Version    string
+	// Collectors holds the last run of every collector, scrapes with a module only run some of them
+	Collectors []CollectorStatus
+}
+
+var (
+	statuses   = make(map[string]TargetStatus)
+	statusesMu sync.Mutex
+)
+
+// LastStatus returns the result of the last scrape of a target, false if it was not scraped yet
+func LastStatus(target string) (TargetStatus, bool) {
+	statusesMu.Lock()
+	defer statusesMu.Unlock()
+
+	status, found := statuses[target]
+	return status, found
+}
+
+// recordStatus stores the result of a scrape. The collectors which didn't run keep their previous result.
+func recordStatus(status *TargetStatus) {
+	statusesMu.Lock()
+	defer statusesMu.Unlock()
+
+	ran := make(map[string]bool)
+	collectors := append([]CollectorStatus(nil), status.Collectors...)
+	for _, c := range collectors {
+		ran[c.Name] = true
+	}
+	previous := statuses[status.Target]
+	for _, c := range previous.Collectors {
+		if !ran[c.Name] {
+			collectors = append(collectors, c)
+		}
+	}
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].Name < collectors[j].Name })
+
+	s := *status
+	s.Collectors = collectors
+	if s.Version == "" {
+		s.Version = previous.Version
+	}
+	statuses[s.Target] = s
+}
+
+// collectorDone records the result of a collector and returns it
+func (s *TargetStatus) collectorDone(name string, start time.Time, err error, timedOut bool) CollectorStatus {
+	// Like in the log, an EOF of the session is not reported as error
+	if err != nil && err.Error() == "EOF" {
+		err = nil
+	}
+	c := CollectorStatus{
+		Name:     name,
+		LastRun:  start,
+		Success:  err == nil && !timedOut,
+		TimedOut: timedOut,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		c.Error = err.Error()
+	} else if timedOut {
+		c.Error = "skipped, the scrape timeout was reached"
+	}
+	s.Collectors = append(s.Collectors, c)
+	return c
+}
+
+// statusKey is the context key of the status of the target being collected
+type statusKey struct{}
+
+func withStatus(ctx context.Context, status *TargetStatus) context.Context {
+	return context.WithValue(ctx, statusKey{}, status)
+}
+
+// setVersion records the Fabric OS version of the target being collected
+func setVersion(ctx context.Context, version string) {
+	if status, ok := ctx.Value(statusKey{}).(*TargetStatus); ok {
+		status.Version = version
+	}
+}
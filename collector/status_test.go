@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordStatus(t *testing.T) {
+	start := time.Now()
+	first := &TargetStatus{Target: "192.0.2.1", LastScrape: start, Success: true}
+	ctx := withStatus(context.Background(), first)
+	setVersion(ctx, "v8.2.1c")
+	first.collectorDone("uptime", start, nil, false)
+	first.collectorDone("sensor", start, errors.New("EOF"), false)
+	first.collectorDone("portstats", start, errors.New("command failed"), false)
+	recordStatus(first)
+
+	// a scrape with a module runs only some collectors and doesn't find the version
+	second := &TargetStatus{Target: "192.0.2.1", LastScrape: start.Add(time.Minute)}
+	second.collectorDone("sensor", start, nil, true)
+	recordStatus(second)
+
+	status, found := LastStatus("192.0.2.1")
+	if !found {
+		t.Fatal("expected the status of the target")
+	}
+	if status.Version != "v8.2.1c" || !status.LastScrape.Equal(second.LastScrape) {
+		t.Errorf("expected the last scrape with the version found before, got %+v", status)
+	}
+	expected := []CollectorStatus{
+		{Name: "portstats", Success: false, Error: "command failed"},
+		{Name: "sensor", Success: false, TimedOut: true, Error: "skipped, the scrape timeout was reached"},
+		{Name: "uptime", Success: true},
+	}
+	if len(status.Collectors) != len(expected) {
+		t.Fatalf("expected %d collectors, got %+v", len(expected), status.Collectors)
+	}
+	for i, c := range status.Collectors {
+		e := expected[i]
+		if c.Name != e.Name || c.Success != e.Success || c.TimedOut != e.TimedOut || c.Error != e.Error {
+			t.Errorf("collector %d = %+v, want %+v", i, c, e)
+		}
+	}
+	if first.Collectors[1].Error != "" || !first.Collectors[1].Success {
+		t.Errorf("expected an EOF not to be reported as error, got %+v", first.Collectors[1])
+	}
+
+	if _, found := LastStatus("192.0.2.2"); found {
+		t.Error("expected no status for a target which was not scraped")
+	}
+	// without a status in the context the version is ignored
+	setVersion(context.Background(), "v9.0.0")
+}
@@ -1,13 +1,16 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 )
 
 var (
@@ -20,6 +23,7 @@ var (
 func init() {
 	registerCollector("uptime", defaultEnabled, NewUptimeCollector)
 	label_name_uptime := append(labelnames, "version")
+	reserveLabels(label_name_uptime...)
 	uptimeDesc = prometheus.NewDesc(prefix+"uptime", "Displays how long the system has been running", label_name_uptime, nil)
 	loadLongtermDesc = prometheus.NewDesc(prefix+"load_longterm", "The average system load over a period of the last 15 minutes.", labelnames, nil)
 	loadMidtermDesc = prometheus.NewDesc(prefix+"load_midterm", "The average system load over a period of the last 5 minutes.", labelnames, nil)
@@ -41,27 +45,26 @@ func (*uptimeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- loadShorttermDesc
 }
 
-func (c *uptimeCollector) Collect(client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
-	log.Debugln("Entering uptime collector ...")
+func (c *uptimeCollector) Collect(ctx context.Context, client *connector.SSHConnection, ch chan<- prometheus.Metric, labelvalue []string) error {
+	logger := logging.FromContext(ctx)
+	level.Debug(logger).Log("msg", "Entering uptime collector ...")
 
-	uptimeResp, err := client.RunCommand("uptime")
+	uptimeResp, err := runCommand(ctx, client, "uptime")
 	if err != nil {
-		log.Errorf("Executing uptime command failed: %s", err)
+		level.Error(logger).Log("msg", "Executing uptime command failed", "err", err)
 		return err
 	}
-	log.Debugln("Response of uptime cmd: ", uptimeResp)
 	// Examples of the returned uptime response string:
 	// 15:39:20 up 5:23, 1 users, load averages: 2.75, 2.60, 2.73
 	// 17:46 up 5 min, 1 users, load averages: 9.30, 5.25, 4.10
 	// 20:46:50 up 216 days, 27 min, 0 users, load average: 0.59, 0.30, 0.19
 	// 0:53:13 up 204 days, 3:34, 1 user, load average: 0.58, 0.67, 0.68
 
-	versionResp, err := client.RunCommand("version")
+	versionResp, err := runCommand(ctx, client, "version")
 	if err != nil {
-		log.Errorf("Executing version command failed: %s", err)
+		level.Error(logger).Log("msg", "Executing version command failed", "err", err)
 		return err
 	}
-	log.Debugln("Response of version cmd: ", versionResp)
 	// Examples of the returned uptime string:
 	// Kernel:     2.6.14.2\nFabric OS:  v8.1.2a\nMade on:    Fri Nov 17 18:46:07 2017\nFlash:\t    Thu Nov 29 20:08:53 2018\nBootProm:   1.0.11\n"
 
@@ -70,8 +73,8 @@ func (c *uptimeCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 	uptimeResp = strings.TrimSpace(uptimeResp)
 	// Split uptime response by whitespaces
 	var uptimeRespSplit []string = strings.Split(uptimeResp, " ")
-	log.Debugln("Splitted uptime response: ", uptimeRespSplit)
-	log.Debugln("# of values in uptimeRespSplit: ", len(uptimeRespSplit))
+	level.Debug(logger).Log("uptimeRespSplit", fmt.Sprint(uptimeRespSplit))
+	level.Debug(logger).Log("msg", "# of values in uptimeRespSplit", "count", len(uptimeRespSplit))
 	var uptimeInSecs float64
 	switch len(uptimeRespSplit) {
 	case 10:
@@ -79,45 +82,46 @@ func (c *uptimeCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 		// 15:39:20 up 5:23, 1 users, load averages: 2.75, 2.60, 2.73
 		days := "0"
 		hoursAndMins := strings.Trim(uptimeRespSplit[2], ",")
-		uptimeInSecs = convertToSeconds(days, hoursAndMins)
+		uptimeInSecs = convertToSeconds(ctx, days, hoursAndMins)
 	case 11:
 		// Examples of the returned uptime response string:
 		// 17:46 up 5 min, 1 users, load averages: 9.30, 5.25, 4.10
 		days := "0"
 		hoursAndMins := "0:" + uptimeRespSplit[2]
-		uptimeInSecs = convertToSeconds(days, hoursAndMins)
+		uptimeInSecs = convertToSeconds(ctx, days, hoursAndMins)
 	case 12:
 		// Example of the returned uptime response string:
 		// 0:53:13 up 204 days, 3:34, 1 user, load average: 0.58, 0.67, 0.68
 		days := uptimeRespSplit[2]
 		hoursAndMins := strings.Trim(uptimeRespSplit[4], ",")
-		uptimeInSecs = convertToSeconds(days, hoursAndMins)
+		uptimeInSecs = convertToSeconds(ctx, days, hoursAndMins)
 	case 13:
 		// Example of the returned uptime response string:
 		// 20:46:50 up 216 days, 27 min, 0 users, load average: 0.59, 0.30, 0.19
 		days := uptimeRespSplit[2]
 		hoursAndMins := "0:" + uptimeRespSplit[4]
-		uptimeInSecs = convertToSeconds(days, hoursAndMins)
+		uptimeInSecs = convertToSeconds(ctx, days, hoursAndMins)
 	default:
-		log.Errorln("Splitted uptime info has less than 11 or more than 13 elements:", len(uptimeRespSplit))
-		log.Infoln("Response of uptime cmd: ", uptimeResp)
-		log.Infoln("Splitted uptime response: ", uptimeRespSplit)
+		parseError(ctx, "Splitted uptime info has less than 11 or more than 13 elements: %d", len(uptimeRespSplit))
+		level.Info(logger).Log("msg", "Response of uptime cmd", "uptimeResp", uptimeResp)
+		level.Info(logger).Log("uptimeRespSplit", fmt.Sprint(uptimeRespSplit))
 	}
-	log.Debugln("uptime in seconds: ", uptimeInSecs)
+	level.Debug(logger).Log("uptimeInSecs", uptimeInSecs)
 
 	// Parse version response string:
 	re := regexp.MustCompile(`v\d+(\.\d+)*(\w)*`)
 	version := re.FindString(versionResp)
-	log.Debugln("version: ", version)
+	level.Debug(logger).Log("version", version)
+	setVersion(ctx, version)
 	labelValueUptime := append(labelvalue, version)
 	// Add Metric
 	ch <- prometheus.MustNewConstMetric(uptimeDesc, prometheus.GaugeValue, uptimeInSecs, labelValueUptime...)
 
-	if *enableFullMetrics == true {
+	if fullMetrics(ctx) {
 		loadLongtermStr := uptimeRespSplit[len(uptimeRespSplit)-3]
 		loadLongterm, err := strconv.ParseFloat(strings.Trim(loadLongtermStr, ","), 64)
 		if err != nil {
-			log.Errorf("load longterm parsing error for %s: %s", loadLongtermStr, err)
+			parseError(ctx, "load longterm parsing error for %s: %s", loadLongtermStr, err)
 			return err
 		}
 		ch <- prometheus.MustNewConstMetric(loadLongtermDesc, prometheus.GaugeValue, loadLongterm, labelvalue...)
@@ -125,7 +129,7 @@ func (c *uptimeCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 		loadMidtermStr := uptimeRespSplit[len(uptimeRespSplit)-2]
 		loadMidterm, err := strconv.ParseFloat(strings.Trim(loadMidtermStr, ","), 64)
 		if err != nil {
-			log.Errorf("load midterm parsing error for %s: %s", loadMidtermStr, err)
+			parseError(ctx, "load midterm parsing error for %s: %s", loadMidtermStr, err)
 			return err
 		}
 		ch <- prometheus.MustNewConstMetric(loadMidtermDesc, prometheus.GaugeValue, loadMidterm, labelvalue...)
@@ -133,42 +137,43 @@ func (c *uptimeCollector) Collect(client *connector.SSHConnection, ch chan<- pro
 		loadShorttermStr := uptimeRespSplit[len(uptimeRespSplit)-1]
 		loadShortterm, err := strconv.ParseFloat(strings.Trim(loadShorttermStr, ",\n"), 64)
 		if err != nil {
-			log.Errorf("loadShortterm parsing error for %s: %s", loadShorttermStr, err)
+			parseError(ctx, "loadShortterm parsing error for %s: %s", loadShorttermStr, err)
 			return err
 		}
 		ch <- prometheus.MustNewConstMetric(loadShorttermDesc, prometheus.GaugeValue, loadShortterm, labelvalue...)
 	}
 
-	log.Debugln("Leaving uptime collector.")
+	level.Debug(logger).Log("msg", "Leaving uptime collector.")
 	return err
 }
 
-func convertToSeconds(daysStr string, hoursAndMinutesStr string) float64 {
+func convertToSeconds(ctx context.Context, daysStr string, hoursAndMinutesStr string) float64 {
+	logger := logging.FromContext(ctx)
 	days, err := strconv.ParseFloat(daysStr, 64)
 	if err != nil {
-		log.Errorf("daysStr parsing error for %s: %s", daysStr, err)
+		parseError(ctx, "daysStr parsing error for %s: %s", daysStr, err)
 		return 0
 	}
-	log.Debugln("uptime days: ", days)
+	level.Debug(logger).Log("days", days)
 	hoursAndMinutesSplit := strings.Split(hoursAndMinutesStr, ":")
-	log.Debugln("hoursAndMinutes: ", hoursAndMinutesSplit)
+	level.Debug(logger).Log("hoursAndMinutes", fmt.Sprint(hoursAndMinutesSplit))
 	var time float64
 	if len(hoursAndMinutesSplit) == 2 {
 		hours, err := strconv.ParseFloat(hoursAndMinutesSplit[0], 64)
 		if err != nil {
-			log.Errorf("hoursAndMinutesSplit[0] parsing error for %s: %s", hoursAndMinutesSplit[0], err)
+			parseError(ctx, "hoursAndMinutesSplit[0] parsing error for %s: %s", hoursAndMinutesSplit[0], err)
 			return 0
 		}
 		minutes, err := strconv.ParseFloat(hoursAndMinutesSplit[1], 64)
 		if err != nil {
-			log.Errorf("hoursAndMinutesSplit[1] parsing error for %s: %s", hoursAndMinutesSplit[1], err)
+			parseError(ctx, "hoursAndMinutesSplit[1] parsing error for %s: %s", hoursAndMinutesSplit[1], err)
 			return 0
 		}
 		time = days*24*60*60 + hours*60*60 + minutes*60
 		return time
 	} else {
-		log.Errorln("Splitted hours_minutes has more or less than 2 elements:", len(hoursAndMinutesSplit))
-		log.Infoln("hoursAndMinutesSplit: ", hoursAndMinutesSplit)
+		parseError(ctx, "Splitted hours_minutes has more or less than 2 elements: %d", len(hoursAndMinutesSplit))
+		level.Info(logger).Log("hoursAndMinutesSplit", fmt.Sprint(hoursAndMinutesSplit))
 		return 0
 	}
 }
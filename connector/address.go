@@ -0,0 +1,57 @@
+package connector
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSSHPort is used for addresses without port when no port is configured
+const defaultSSHPort = 22
+
+// SplitAddress splits the address of a switch or jump host into the canonical host and the port.
+// It accepts IPv4 and IPv6 addresses, with or without brackets and zone, and DNS names, optionally
+// followed by a port. IPv6 addresses are returned in their shortest form and DNS names in lower case
+// without the trailing dot, so that one switch always has the same pool and known_hosts entry.
+// defaultPort is returned for addresses without port.
+func SplitAddress(address string, defaultPort uint16) (string, uint16, error) {
+	host, port := address, defaultPort
+	if h, p, err := net.SplitHostPort(address); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return "", 0, errors.Errorf("invalid port %q", p)
+		}
+		host, port = h, uint16(n)
+	} else if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		host = address[1 : len(address)-1]
+	}
+
+	ip, zone := host, ""
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		ip, zone = host[:i], host[i:]
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String() + zone, port, nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if zone != "" || !hostnameRegexp.MatchString(name) {
+		return "", 0, errors.Errorf("%q is neither an IP address nor a host name", host)
+	}
+	return name, port, nil
+}
+
+// CanonicalAddress returns the address in the host:port form used to connect to the switch and to store
+// its host key, IPv6 addresses are enclosed in brackets. defaultPort, or 22 if it is 0, is used for
+// addresses without port.
+func CanonicalAddress(address string, defaultPort uint16) (string, error) {
+	if defaultPort == 0 {
+		defaultPort = defaultSSHPort
+	}
+	host, port, err := SplitAddress(address, defaultPort)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
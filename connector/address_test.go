@@ -0,0 +1,87 @@
+package connector
+
+import "testing"
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+		port    uint16
+	}{
+		{"172.16.64.17", "172.16.64.17", 22},
+		{"172.16.64.17:2222", "172.16.64.17", 2222},
+		{"2001:db8::17", "2001:db8::17", 22},
+		{"2001:DB8:0:0:0:0:0:17", "2001:db8::17", 22},
+		{"[2001:db8::17]", "2001:db8::17", 22},
+		{"[2001:db8::17]:2222", "2001:db8::17", 2222},
+		{"::ffff:172.16.64.17", "172.16.64.17", 22},
+		{"fe80::1%eth0", "fe80::1%eth0", 22},
+		{"[fe80::1%eth0]:2222", "fe80::1%eth0", 2222},
+		{"SAN1.Example.com", "san1.example.com", 22},
+		{"san1.example.com.:2222", "san1.example.com", 2222},
+		{"san-1", "san-1", 22},
+	}
+	for _, test := range tests {
+		host, port, err := SplitAddress(test.address, 22)
+		if err != nil {
+			t.Errorf("SplitAddress(%q) failed: %s", test.address, err)
+			continue
+		}
+		if host != test.host || port != test.port {
+			t.Errorf("SplitAddress(%q) = %q, %d, want %q, %d", test.address, host, port, test.host, test.port)
+		}
+	}
+
+	for _, address := range []string{
+		"",
+		"172.16.64.17:0",
+		"172.16.64.17:65536",
+		"172.16.64.17:ssh",
+		"[2001:db8::17",
+		"san_1.example.com",
+		"-san1",
+		"san1%eth0",
+	} {
+		if host, port, err := SplitAddress(address, 22); err == nil {
+			t.Errorf("SplitAddress(%q) = %q, %d, want an error", address, host, port)
+		}
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	tests := []struct {
+		address     string
+		defaultPort uint16
+		expected    string
+	}{
+		{"172.16.64.17", 0, "172.16.64.17:22"},
+		{"172.16.64.17", 2222, "172.16.64.17:2222"},
+		{"172.16.64.17:830", 2222, "172.16.64.17:830"},
+		{"2001:db8:0::17", 0, "[2001:db8::17]:22"},
+		{"[2001:db8::17]", 0, "[2001:db8::17]:22"},
+		{"[2001:DB8::17]:2222", 0, "[2001:db8::17]:2222"},
+		{"fe80::1%eth0", 0, "[fe80::1%eth0]:22"},
+		{"SAN1.example.com.", 0, "san1.example.com:22"},
+	}
+	for _, test := range tests {
+		got, err := CanonicalAddress(test.address, test.defaultPort)
+		if err != nil {
+			t.Errorf("CanonicalAddress(%q, %d) failed: %s", test.address, test.defaultPort, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("CanonicalAddress(%q, %d) = %q, want %q", test.address, test.defaultPort, got, test.expected)
+		}
+	}
+
+	// The same switch has to end up in the same pool and known_hosts entry however it is written
+	a, _ := CanonicalAddress("[2001:0db8::0017]:22", 0)
+	b, _ := CanonicalAddress("2001:db8::17", 22)
+	if a != b {
+		t.Errorf("CanonicalAddress() returned %q and %q for the same switch", a, b)
+	}
+
+	if got, err := CanonicalAddress("san_1", 0); err == nil {
+		t.Errorf("CanonicalAddress(%q) = %q, want an error", "san_1", got)
+	}
+}
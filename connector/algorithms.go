@@ -0,0 +1,271 @@
+package connector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithm presets which can be selected per target or globally
+const (
+	// AlgorithmsPresetDefault uses the algorithms preferred by the SSH library
+	AlgorithmsPresetDefault = "default"
+	// AlgorithmsPresetLegacy additionally offers the older algorithms FOS 7.x switches require
+	AlgorithmsPresetLegacy = "legacy"
+	// AlgorithmsPresetFIPS only offers FIPS 140-2 approved algorithms
+	AlgorithmsPresetFIPS = "fips"
+)
+
+// algorithmSet is the list of algorithms offered for each part of the handshake
+type algorithmSet struct {
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+// supportedAlgorithms lists all algorithms the SSH library implements on the client side
+var supportedAlgorithms = algorithmSet{
+	Ciphers: []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"arcfour256", "arcfour128", "arcfour", "aes128-cbc", "3des-cbc",
+	},
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	},
+	HostKeyAlgorithms: []string{
+		ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01,
+		ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+		ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		ssh.KeyAlgoED25519,
+	},
+}
+
+var algorithmPresets = map[string]algorithmSet{
+	// The defaults of the SSH library, spelled out to know what was offered in the handshake
+	AlgorithmsPresetDefault: {
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		},
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		},
+		MACs:              supportedAlgorithms.MACs,
+		HostKeyAlgorithms: supportedAlgorithms.HostKeyAlgorithms,
+	},
+	AlgorithmsPresetLegacy: supportedAlgorithms,
+	AlgorithmsPresetFIPS: {
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		},
+		KeyExchanges: []string{
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeyAlgorithms: []string{
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+		},
+	},
+}
+
+// resolveAlgorithms applies the explicitly configured lists on top of the preset
+// and rejects algorithms the SSH library doesn't implement
+func resolveAlgorithms(a Algorithms) (algorithmSet, error) {
+	preset := a.Preset
+	if preset == "" {
+		preset = AlgorithmsPresetDefault
+	}
+	set, found := algorithmPresets[preset]
+	if !found {
+		return set, errors.Errorf("unknown algorithms preset %q", preset)
+	}
+
+	lists := []struct {
+		kind       string
+		configured []string
+		supported  []string
+		result     *[]string
+	}{
+		{"cipher", a.Ciphers, supportedAlgorithms.Ciphers, &set.Ciphers},
+		{"key exchange", a.KeyExchanges, supportedAlgorithms.KeyExchanges, &set.KeyExchanges},
+		{"MAC", a.MACs, supportedAlgorithms.MACs, &set.MACs},
+		{"host key algorithm", a.HostKeyAlgorithms, supportedAlgorithms.HostKeyAlgorithms, &set.HostKeyAlgorithms},
+	}
+	for _, list := range lists {
+		if len(list.configured) == 0 {
+			continue
+		}
+		for _, name := range list.configured {
+			if !contains(list.supported, name) {
+				return set, errors.Errorf("unsupported %s %q", list.kind, name)
+			}
+		}
+		*list.result = list.configured
+	}
+	return set, nil
+}
+
+// NegotiatedAlgorithms are the algorithms agreed on in the handshake with a device
+type NegotiatedAlgorithms struct {
+	KeyExchange string
+	HostKey     string
+	Cipher      string
+	MAC         string
+}
+
+// negotiate repeats the algorithm negotiation of RFC 4253 section 7.1 with the lists offered
+// by the client and the server. The library doesn't expose the result of the handshake.
+func negotiate(config *ssh.ClientConfig, server *kexInit) NegotiatedAlgorithms {
+	n := NegotiatedAlgorithms{
+		KeyExchange: firstCommon(config.KeyExchanges, server.KeyExchanges),
+		HostKey:     firstCommon(config.HostKeyAlgorithms, server.HostKeyAlgorithms),
+		Cipher:      firstCommon(config.Ciphers, server.Ciphers),
+		MAC:         firstCommon(config.MACs, server.MACs),
+	}
+	if strings.Contains(n.Cipher, "-gcm@") || strings.HasPrefix(n.Cipher, "chacha20-poly1305") {
+		// AEAD ciphers authenticate the packets themselves
+		n.MAC = "implicit"
+	}
+	return n
+}
+
+func firstCommon(client []string, server []string) string {
+	for _, c := range client {
+		if contains(server, c) {
+			return c
+		}
+	}
+	return ""
+}
+
+// kexInit holds the client to server algorithm lists of a SSH_MSG_KEXINIT message
+type kexInit struct {
+	KeyExchanges      []string
+	HostKeyAlgorithms []string
+	Ciphers           []string
+	MACs              []string
+}
+
+// kexInitConn records the start of the data sent by the server until its
+// first SSH_MSG_KEXINIT message, which is sent before encryption starts
+type kexInitConn struct {
+	net.Conn
+	buf    bytes.Buffer
+	parsed *kexInit
+	done   bool
+	mu     sync.Mutex
+}
+
+const (
+	msgKexInit = 20
+	// maxKexInitRecord stops recording if no KEXINIT was found within this many bytes
+	maxKexInitRecord = 64 * 1024
+)
+
+func (c *kexInitConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done && n > 0 {
+		c.buf.Write(b[:n])
+		c.parse()
+	}
+	return n, err
+}
+
+// serverKexInit returns the algorithms offered by the server, nil if they were not seen
+func (c *kexInitConn) serverKexInit() *kexInit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.parsed
+}
+
+// parse looks for the first binary packet following the identification line of the server
+func (c *kexInitConn) parse() {
+	data := c.buf.Bytes()
+	if len(data) > maxKexInitRecord {
+		c.done = true
+		c.buf = bytes.Buffer{}
+		return
+	}
+	// The server may send other lines before its identification string
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			return
+		}
+		line := data[:idx]
+		data = data[idx+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+	if len(data) < 5 {
+		return
+	}
+	length := int(binary.BigEndian.Uint32(data))
+	if len(data) < 4+length {
+		return
+	}
+	padding := int(data[4])
+	c.done = true
+	c.buf = bytes.Buffer{}
+	if length < padding+1 || data[5] != msgKexInit {
+		return
+	}
+	c.parsed = parseKexInit(data[5 : 4+length-padding])
+}
+
+func parseKexInit(payload []byte) *kexInit {
+	// Skip the message type and the 16 byte cookie
+	rest := payload[1:]
+	if len(rest) < 16 {
+		return nil
+	}
+	rest = rest[16:]
+	var lists [6][]string
+	for i := range lists {
+		if len(rest) < 4 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint32(rest))
+		if len(rest) < 4+length {
+			return nil
+		}
+		lists[i] = strings.Split(string(rest[4:4+length]), ",")
+		rest = rest[4+length:]
+	}
+	// kex, host key, ciphers client to server, ciphers server to client, MACs client to server, ...
+	return &kexInit{
+		KeyExchanges:      lists[0],
+		HostKeyAlgorithms: lists[1],
+		Ciphers:           lists[2],
+		MACs:              lists[4],
+	}
+}
@@ -0,0 +1,287 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fosKexInit are the algorithm lists sent by the SSH server of a switch running FOS v9.1
+var fosKexInit = [10]string{
+	"curve25519-sha256,curve25519-sha256@libssh.org,ecdh-sha2-nistp256,ecdh-sha2-nistp384,ecdh-sha2-nistp521,diffie-hellman-group16-sha512,diffie-hellman-group14-sha256",
+	"rsa-sha2-512,rsa-sha2-256,ssh-rsa,ecdsa-sha2-nistp256,ssh-ed25519",
+	"aes256-gcm@openssh.com,aes128-gcm@openssh.com,aes256-ctr,aes192-ctr,aes128-ctr",
+	"aes256-gcm@openssh.com,aes128-gcm@openssh.com,aes256-ctr,aes192-ctr,aes128-ctr",
+	"hmac-sha2-512-etm@openssh.com,hmac-sha2-256-etm@openssh.com,hmac-sha2-512,hmac-sha2-256",
+	"hmac-sha2-512-etm@openssh.com,hmac-sha2-256-etm@openssh.com,hmac-sha2-512,hmac-sha2-256",
+	"none,zlib@openssh.com",
+	"none,zlib@openssh.com",
+	"",
+	"",
+}
+
+// kexInitPayload builds the payload of a SSH_MSG_KEXINIT message with the name lists
+func kexInitPayload(lists [10]string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(msgKexInit)
+	b.Write(make([]byte, 16))
+	for _, list := range lists {
+		binary.Write(&b, binary.BigEndian, uint32(len(list)))
+		b.WriteString(list)
+	}
+	// first_kex_packet_follows and the reserved field
+	b.Write(make([]byte, 5))
+	return b.Bytes()
+}
+
+// kexInitPacket wraps the payload into an unencrypted binary packet
+func kexInitPacket(payload []byte) []byte {
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(1+len(payload)+padding))
+	b.WriteByte(byte(padding))
+	b.Write(payload)
+	b.Write(make([]byte, padding))
+	return b.Bytes()
+}
+
+func TestParseKexInit(t *testing.T) {
+	parsed := parseKexInit(kexInitPayload(fosKexInit))
+	if parsed == nil {
+		t.Fatal("parseKexInit() = nil")
+	}
+	expected := &kexInit{
+		KeyExchanges:      strings.Split(fosKexInit[0], ","),
+		HostKeyAlgorithms: strings.Split(fosKexInit[1], ","),
+		Ciphers:           strings.Split(fosKexInit[2], ","),
+		MACs:              strings.Split(fosKexInit[4], ","),
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("parseKexInit() = %+v, want %+v", parsed, expected)
+	}
+
+	payload := kexInitPayload(fosKexInit)
+	for _, length := range []int{1, 10, 17, 20, 100} {
+		if parsed := parseKexInit(payload[:length]); parsed != nil {
+			t.Errorf("parseKexInit() of %d bytes = %+v, want nil", length, parsed)
+		}
+	}
+}
+
+// recordedConn returns the recorded data in chunks of the given size
+type recordedConn struct {
+	net.Conn
+	data  []byte
+	chunk int
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.chunk
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	n = copy(b, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func TestKexInitConn(t *testing.T) {
+	// Some switches print a banner before their identification string
+	var data []byte
+	data = append(data, "Unauthorized access prohibited\r\n"...)
+	data = append(data, "SSH-2.0-OpenSSH_7.4\r\n"...)
+	data = append(data, kexInitPacket(kexInitPayload(fosKexInit))...)
+
+	for _, chunk := range []int{1, 7, 64, len(data)} {
+		conn := &kexInitConn{Conn: &recordedConn{data: append([]byte(nil), data...), chunk: chunk}}
+		if _, err := io.Copy(ioutil.Discard, conn); err != nil {
+			t.Fatal(err)
+		}
+		parsed := conn.serverKexInit()
+		if parsed == nil {
+			t.Errorf("serverKexInit() = nil with reads of %d bytes", chunk)
+			continue
+		}
+		if parsed.Ciphers[0] != "aes256-gcm@openssh.com" || parsed.MACs[3] != "hmac-sha2-256" {
+			t.Errorf("serverKexInit() = %+v with reads of %d bytes", parsed, chunk)
+		}
+	}
+
+	// A packet which isn't a KEXINIT stops the recording
+	other := kexInitPayload(fosKexInit)
+	other[0] = 21
+	conn := &kexInitConn{Conn: &recordedConn{data: append([]byte("SSH-2.0-OpenSSH_7.4\r\n"), kexInitPacket(other)...), chunk: 64}}
+	io.Copy(ioutil.Discard, conn)
+	if parsed := conn.serverKexInit(); parsed != nil {
+		t.Errorf("serverKexInit() = %+v for another message, want nil", parsed)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	server := parseKexInit(kexInitPayload(fosKexInit))
+	tests := []struct {
+		name     string
+		config   ssh.Config
+		hostKeys []string
+		expected NegotiatedAlgorithms
+	}{
+		{
+			name: "client preference wins",
+			config: ssh.Config{
+				KeyExchanges: []string{"diffie-hellman-group14-sha256", "curve25519-sha256"},
+				Ciphers:      []string{"aes128-ctr", "aes256-ctr"},
+				MACs:         []string{"hmac-sha2-256", "hmac-sha2-512"},
+			},
+			hostKeys: []string{"ssh-ed25519", "rsa-sha2-256"},
+			expected: NegotiatedAlgorithms{
+				KeyExchange: "diffie-hellman-group14-sha256",
+				HostKey:     "ssh-ed25519",
+				Cipher:      "aes128-ctr",
+				MAC:         "hmac-sha2-256",
+			},
+		},
+		{
+			name: "AEAD cipher",
+			config: ssh.Config{
+				KeyExchanges: []string{"curve25519-sha256@libssh.org"},
+				Ciphers:      []string{"chacha20-poly1305@openssh.com", "aes128-gcm@openssh.com"},
+				MACs:         []string{"hmac-sha2-256"},
+			},
+			hostKeys: []string{"rsa-sha2-512"},
+			expected: NegotiatedAlgorithms{
+				KeyExchange: "curve25519-sha256@libssh.org",
+				HostKey:     "rsa-sha2-512",
+				Cipher:      "aes128-gcm@openssh.com",
+				MAC:         "implicit",
+			},
+		},
+		{
+			name: "nothing in common",
+			config: ssh.Config{
+				KeyExchanges: []string{"diffie-hellman-group1-sha1"},
+				Ciphers:      []string{"aes128-cbc"},
+				MACs:         []string{"hmac-sha1"},
+			},
+			hostKeys: []string{"ssh-dss"},
+			expected: NegotiatedAlgorithms{},
+		},
+	}
+	for _, test := range tests {
+		config := &ssh.ClientConfig{Config: test.config, HostKeyAlgorithms: test.hostKeys}
+		if got := negotiate(config, server); got != test.expected {
+			t.Errorf("%s: negotiate() = %+v, want %+v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestResolveAlgorithms(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithms Algorithms
+		ciphers    []string
+		macs       []string
+		fails      bool
+	}{
+		{
+			name:       "default preset",
+			algorithms: Algorithms{},
+			ciphers:    algorithmPresets[AlgorithmsPresetDefault].Ciphers,
+			macs:       supportedAlgorithms.MACs,
+		},
+		{
+			name:       "legacy preset offers cbc ciphers",
+			algorithms: Algorithms{Preset: AlgorithmsPresetLegacy},
+			ciphers:    supportedAlgorithms.Ciphers,
+			macs:       supportedAlgorithms.MACs,
+		},
+		{
+			name:       "configured lists replace the preset",
+			algorithms: Algorithms{Preset: AlgorithmsPresetFIPS, Ciphers: []string{"aes256-ctr"}},
+			ciphers:    []string{"aes256-ctr"},
+			macs:       algorithmPresets[AlgorithmsPresetFIPS].MACs,
+		},
+		{
+			name:       "unknown preset",
+			algorithms: Algorithms{Preset: "modern"},
+			fails:      true,
+		},
+		{
+			name:       "unsupported cipher",
+			algorithms: Algorithms{Ciphers: []string{"blowfish-cbc"}},
+			fails:      true,
+		},
+		{
+			name:       "unsupported MAC",
+			algorithms: Algorithms{MACs: []string{"hmac-md5"}},
+			fails:      true,
+		},
+	}
+	for _, test := range tests {
+		set, err := resolveAlgorithms(test.algorithms)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(set.Ciphers, test.ciphers) {
+			t.Errorf("%s: ciphers = %v, want %v", test.name, set.Ciphers, test.ciphers)
+		}
+		if !reflect.DeepEqual(set.MACs, test.macs) {
+			t.Errorf("%s: MACs = %v, want %v", test.name, set.MACs, test.macs)
+		}
+	}
+}
+
+func TestAlgorithmsInherit(t *testing.T) {
+	global := Algorithms{Preset: AlgorithmsPresetLegacy, Ciphers: []string{"aes128-cbc"}, MACs: []string{"hmac-sha1"}}
+	target := Algorithms{Ciphers: []string{"aes256-ctr"}}
+
+	a := target.inherit(global)
+	expected := Algorithms{Preset: AlgorithmsPresetLegacy, Ciphers: []string{"aes256-ctr"}, MACs: []string{"hmac-sha1"}}
+	if !reflect.DeepEqual(a, expected) {
+		t.Errorf("inherit() = %+v, want %+v", a, expected)
+	}
+}
+
+func TestConnectNegotiatesConfiguredAlgorithms(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.Algorithms = Algorithms{Ciphers: []string{"aes256-ctr"}, MACs: []string{"hmac-sha2-512"}}
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	if c.algorithms.Cipher != "aes256-ctr" || c.algorithms.MAC != "hmac-sha2-512" {
+		t.Errorf("unexpected algorithms negotiated: %+v", c.algorithms)
+	}
+	if c.algorithms.KeyExchange == "" || c.algorithms.HostKey == "" {
+		t.Errorf("expected the key exchange and host key algorithm to be known: %+v", c.algorithms)
+	}
+}
@@ -0,0 +1,164 @@
+package connector
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Names of the SSH authentication methods which can be ordered with authMethods
+const (
+	AuthPublicKey           = "publickey"
+	AuthAgent               = "agent"
+	AuthPassword            = "password"
+	AuthKeyboardInteractive = "keyboard-interactive"
+)
+
+// DefaultAuthMethods is the order in which the authentication methods are tried by default
+var DefaultAuthMethods = []string{AuthPublicKey, AuthAgent, AuthPassword, AuthKeyboardInteractive}
+
+var (
+	sharedAgent   agent.ExtendedAgent
+	sharedAgentMu sync.Mutex
+)
+
+// agentSigners returns the keys held by the agent listening on SSH_AUTH_SOCK.
+// The connection to the agent is shared by all targets and re-established if it breaks.
+func agentSigners() ([]ssh.Signer, error) {
+	sharedAgentMu.Lock()
+	defer sharedAgentMu.Unlock()
+
+	if sharedAgent == nil {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not connect to the ssh agent")
+		}
+		sharedAgent = agent.NewClient(conn)
+	}
+	signers, err := sharedAgent.Signers()
+	if err != nil {
+		sharedAgent = nil
+		return nil, errors.Wrap(err, "could not list the keys of the ssh agent")
+	}
+	return signers, nil
+}
+
+// loadPrivateKey reads and decrypts the private key file of a target
+func loadPrivateKey(file string, passphrase string) (ssh.Signer, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read private key %s", file)
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse private key %s", file)
+	}
+	return signer, nil
+}
+
+// authMethods builds the SSH authentication methods of a target in the configured order.
+// Methods without credentials are left out. The key file and the agent both use the
+// publickey method, so their keys are offered together at the position of the first of them.
+func authMethods(creds Credentials, order []string) ([]ssh.AuthMethod, error) {
+	if len(creds.AuthMethods) > 0 {
+		order = creds.AuthMethods
+	}
+
+	var methods []ssh.AuthMethod
+	publicKeyAdded := false
+	for _, name := range order {
+		switch name {
+		case AuthPublicKey, AuthAgent:
+			if publicKeyAdded || (creds.PrivateKeyFile == "" && !creds.UseAgent) {
+				continue
+			}
+			var keySigner ssh.Signer
+			if creds.PrivateKeyFile != "" && contains(order, AuthPublicKey) {
+				passphrase, err := creds.PrivateKeyPassphrase.Value()
+				if err != nil {
+					return nil, err
+				}
+				signer, err := loadPrivateKey(creds.PrivateKeyFile, passphrase)
+				if err != nil {
+					return nil, err
+				}
+				keySigner = signer
+			}
+			useAgent := creds.UseAgent && contains(order, AuthAgent)
+			keyFirst := name == AuthPublicKey
+			methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				var signers []ssh.Signer
+				if keySigner != nil && keyFirst {
+					signers = append(signers, keySigner)
+				}
+				if useAgent {
+					agentKeys, err := agentSigners()
+					if err != nil && keySigner == nil {
+						return nil, err
+					}
+					signers = append(signers, agentKeys...)
+				}
+				if keySigner != nil && !keyFirst {
+					signers = append(signers, keySigner)
+				}
+				return signers, nil
+			}))
+			publicKeyAdded = true
+		case AuthPassword:
+			// The password is looked up on every login, so that rotated passwords are picked up
+			if creds.hasPassword() {
+				methods = append(methods, ssh.PasswordCallback(creds.password))
+			}
+		case AuthKeyboardInteractive:
+			if creds.hasPassword() {
+				methods = append(methods, ssh.KeyboardInteractive(passwordChallenge(creds.password)))
+			}
+		default:
+			return nil, errors.Errorf("unknown authentication method %q", name)
+		}
+	}
+	if len(methods) == 0 {
+		return nil, errors.Errorf("no credentials configured for user %s", creds.Userid)
+	}
+	return methods, nil
+}
+
+// passwordChallenge answers the password prompts of switches which only advertise keyboard-interactive
+func passwordChallenge(lookup func() (string, error)) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		password, err := lookup()
+		if err != nil {
+			return nil, err
+		}
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = password
+			}
+		}
+		return answers, nil
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeKeyFile stores the test key of the given name as PEM file, encrypted if a passphrase is given
+func writeKeyFile(t *testing.T, dir string, name string, passphrase string) string {
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey(t, name))}
+	if passphrase != "" {
+		var err error
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestAuthMethods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := writeKeyFile(t, dir, "client", "")
+
+	tests := []struct {
+		name     string
+		creds    Credentials
+		order    []string
+		expected int
+		fails    bool
+	}{
+		{
+			name:     "password only",
+			creds:    Credentials{Userid: "admin", Password: "secret"},
+			order:    DefaultAuthMethods,
+			expected: 2,
+		},
+		{
+			name:     "key file and agent share one method",
+			creds:    Credentials{Userid: "admin", PrivateKeyFile: keyFile, UseAgent: true},
+			order:    DefaultAuthMethods,
+			expected: 1,
+		},
+		{
+			name:     "all credentials",
+			creds:    Credentials{Userid: "admin", Password: "secret", PrivateKeyFile: keyFile},
+			order:    DefaultAuthMethods,
+			expected: 3,
+		},
+		{
+			name:     "target overrides the order",
+			creds:    Credentials{Userid: "admin", Password: "secret", PrivateKeyFile: keyFile, AuthMethods: []string{AuthPassword}},
+			order:    DefaultAuthMethods,
+			expected: 1,
+		},
+		{
+			name:  "no credentials",
+			creds: Credentials{Userid: "admin"},
+			order: DefaultAuthMethods,
+			fails: true,
+		},
+		{
+			name:  "method without credentials",
+			creds: Credentials{Userid: "admin", Password: "secret"},
+			order: []string{AuthPublicKey},
+			fails: true,
+		},
+		{
+			name:  "unknown method",
+			creds: Credentials{Userid: "admin", Password: "secret"},
+			order: []string{"hostbased"},
+			fails: true,
+		},
+		{
+			name:  "missing key file",
+			creds: Credentials{Userid: "admin", PrivateKeyFile: filepath.Join(dir, "missing")},
+			order: DefaultAuthMethods,
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		methods, err := authMethods(test.creds, test.order)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(methods) != test.expected {
+			t.Errorf("%s: expected %d methods, got %d", test.name, test.expected, len(methods))
+		}
+	}
+}
+
+func TestConnectWithPrivateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.authorizedKey = testSigner(t, "client").PublicKey()
+	})
+	defer s.close()
+
+	tests := []struct {
+		encryptedWith string
+		passphrase    string
+		fails         bool
+	}{
+		{"", "", false},
+		{"passphrase", "passphrase", false},
+		{"passphrase", "wrong", true},
+	}
+	for _, test := range tests {
+		target := s.target()
+		target.Password = ""
+		target.PrivateKeyFile = writeKeyFile(t, dir, "client", test.encryptedWith)
+		target.PrivateKeyPassphrase = Secret(test.passphrase)
+
+		m := newTestManager(s)
+		c, err := m.Connect(context.Background(), target)
+		if test.fails {
+			if err == nil {
+				t.Errorf("passphrase %q: expected an error", test.passphrase)
+				m.Release(c)
+			}
+		} else if err != nil {
+			t.Errorf("passphrase %q: %v", test.passphrase, err)
+		} else {
+			m.Release(c)
+		}
+		m.Close()
+	}
+}
+
+func TestConnectWithAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: testKey(t, "client")}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+
+	old := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	defer os.Setenv("SSH_AUTH_SOCK", old)
+	resetAgent := func() {
+		sharedAgentMu.Lock()
+		sharedAgent = nil
+		sharedAgentMu.Unlock()
+	}
+	resetAgent()
+	defer resetAgent()
+
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.authorizedKey = testSigner(t, "client").PublicKey()
+	})
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.Password = ""
+	target.UseAgent = true
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Release(c)
+}
+
+func TestConnectWithKeyboardInteractive(t *testing.T) {
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.keyboardInteractiveOnly = true
+	})
+	defer s.close()
+
+	for _, order := range [][]string{DefaultAuthMethods, {AuthKeyboardInteractive}} {
+		m := newTestManager(s, WithAuthMethods(order))
+		c, err := m.Connect(context.Background(), s.target())
+		if err != nil {
+			t.Errorf("order %v: %v", order, err)
+		} else {
+			m.Release(c)
+		}
+		m.Close()
+	}
+
+	m := newTestManager(s, WithAuthMethods([]string{AuthPassword}))
+	defer m.Close()
+	if _, err := m.Connect(context.Background(), s.target()); err == nil {
+		t.Error("expected the password method to be rejected")
+	}
+}
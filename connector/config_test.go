@@ -0,0 +1,230 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDefaultsAndAuths(t *testing.T) {
+	names := CollectorNames
+	CollectorNames = []string{"uptime", "sensor"}
+	defer func() { CollectorNames = names }()
+
+	cfg, err := loadConfig(t, `
+auths:
+  san:
+    userid: monitor
+    password: from-profile
+    authMethods: [password]
+  jump:
+    userid: jumper
+    privateKeyFile: /etc/fabricos/id_rsa
+defaults:
+  port: 2222
+  commandTimeout: 30s
+  collectors: [uptime]
+targets:
+- ipAddress: 192.0.2.1
+  auth: san
+- ipAddress: 192.0.2.2:22
+  auth: san
+  userid: admin
+  password: own
+  commandTimeout: 5s
+  collectors: [sensor]
+  proxyJump:
+  - host: jump.example.com
+    auth: jump
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := cfg.Targets[0]
+	if first.Userid != "monitor" || first.Password != "from-profile" || !reflect.DeepEqual(first.AuthMethods, []string{AuthPassword}) {
+		t.Errorf("expected the credentials of the profile, got %+v", first.Credentials)
+	}
+	if first.Transport != TransportSSH || first.Port != 2222 || first.ConnectTimeout != 10*time.Second || first.CommandTimeout != 30*time.Second {
+		t.Errorf("expected the defaults, got %+v", first)
+	}
+	if !reflect.DeepEqual(first.Collectors, []string{"uptime"}) {
+		t.Errorf("expected the default collectors, got %v", first.Collectors)
+	}
+	if key, host := poolKey(first); key != "monitor@192.0.2.1:2222" || host != "192.0.2.1:2222" {
+		t.Errorf("poolKey() = %s, %s", key, host)
+	}
+
+	second := cfg.Targets[1]
+	if second.Userid != "admin" || second.Password != "own" {
+		t.Errorf("expected the credentials of the target to take precedence, got %+v", second.Credentials)
+	}
+	if second.CommandTimeout != 5*time.Second || !reflect.DeepEqual(second.Collectors, []string{"sensor"}) {
+		t.Errorf("expected the settings of the target to take precedence, got %+v", second)
+	}
+	if _, host := poolKey(second); host != "192.0.2.2:22" {
+		t.Errorf("expected the port of the ipAddress to take precedence, got %s", host)
+	}
+	if hop := second.ProxyJump[0]; hop.Userid != "jumper" || hop.PrivateKeyFile != "/etc/fabricos/id_rsa" {
+		t.Errorf("expected the credentials of the jump host profile, got %+v", hop.Credentials)
+	}
+}
+
+func TestDefaultLabels(t *testing.T) {
+	cfg, err := loadConfig(t, `
+defaults:
+  fullMetrics: true
+  pollInterval: 1m
+  labels:
+    site: fra
+    fabric: a
+targets:
+- ipAddress: 192.0.2.1
+  userid: admin
+  password: secret
+- ipAddress: 192.0.2.2
+  userid: admin
+  password: secret
+  fullMetrics: false
+  pollInterval: 0s
+  labels:
+    fabric: b
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := cfg.Targets[0], cfg.Targets[1]
+	if !reflect.DeepEqual(first.Labels, map[string]string{"site": "fra", "fabric": "a"}) {
+		t.Errorf("expected the default labels, got %v", first.Labels)
+	}
+	if !reflect.DeepEqual(second.Labels, map[string]string{"site": "fra", "fabric": "b"}) {
+		t.Errorf("expected the labels of the target to take precedence, got %v", second.Labels)
+	}
+	if first.FullMetrics == nil || !*first.FullMetrics {
+		t.Error("expected full metrics to be enabled by the defaults")
+	}
+	if second.FullMetrics == nil || *second.FullMetrics {
+		t.Error("expected the target to disable full metrics")
+	}
+	if first.PollInterval == nil || *first.PollInterval != time.Minute {
+		t.Errorf("expected the default pollInterval, got %v", first.PollInterval)
+	}
+	if second.PollInterval == nil || *second.PollInterval != 0 {
+		t.Errorf("expected the target to disable polling, got %v", second.PollInterval)
+	}
+	// the defaults are copied, not shared between the targets
+	if first.Labels["fabric"] != "a" || cfg.Defaults.Labels["fabric"] != "a" {
+		t.Errorf("expected the default labels to stay unchanged, got %v", cfg.Defaults.Labels)
+	}
+}
+
+func TestModuleApply(t *testing.T) {
+	enabled, disabled := true, false
+	target := Targets{IpAddress: "192.0.2.1", Collectors: []string{"uptime", "sensor"}, FullMetrics: &enabled}
+
+	tests := []struct {
+		module      Module
+		collectors  []string
+		fullMetrics *bool
+	}{
+		{Module{}, []string{"uptime", "sensor"}, &enabled},
+		{Module{Collectors: []string{"sensor"}}, []string{"sensor"}, &enabled},
+		{Module{FullMetrics: &disabled}, []string{"uptime", "sensor"}, &disabled},
+	}
+	for i, test := range tests {
+		applied := test.module.Apply(target)
+		if !reflect.DeepEqual(applied.Collectors, test.collectors) || *applied.FullMetrics != *test.fullMetrics {
+			t.Errorf("%d: Apply() = %v, %v, want %v, %v", i, applied.Collectors, *applied.FullMetrics, test.collectors, *test.fullMetrics)
+		}
+		if applied.IpAddress != target.IpAddress {
+			t.Errorf("%d: expected the other settings of the target to be kept", i)
+		}
+	}
+}
+
+func TestAdHocTarget(t *testing.T) {
+	cfg, err := loadConfig(t, `
+auths:
+  san:
+    userid: monitor
+    password: secret
+  empty:
+    userid: monitor
+defaults:
+  port: 2222
+  labels:
+    site: fra
+targets: []
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := cfg.AdHocTarget("192.0.2.9", "san")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Userid != "monitor" || target.Password != "secret" || target.Port != 2222 || target.Labels["site"] != "fra" {
+		t.Errorf("expected the profile and the defaults, got %+v", target)
+	}
+
+	for _, test := range []struct{ address, auth string }{
+		{"192.0.2.9", "unknown"},
+		{"192.0.2.9", "empty"},
+		{"192.0.2.9:99999", "san"},
+		{"", "san"},
+	} {
+		if _, err := cfg.AdHocTarget(test.address, test.auth); err == nil {
+			t.Errorf("AdHocTarget(%q, %q): expected an error", test.address, test.auth)
+		}
+	}
+}
+
+func TestDiscoveredTarget(t *testing.T) {
+	names := CollectorNames
+	CollectorNames = []string{"uptime", "sensor"}
+	defer func() { CollectorNames = names }()
+
+	cfg, err := loadConfig(t, `
+defaults:
+  collectors: [uptime]
+  labels:
+    site: fra
+targets:
+- ipAddress: 192.0.2.1
+  userid: monitor
+  password: secret
+  port: 2222
+  discover: true
+  commandTimeout: 30s
+  collectors: [sensor]
+  commandMode: shell
+  labels:
+    role: seed
+  hostKeyPolicy: pinned
+  hostKeyFingerprint: SHA256:AAAA
+  proxyJump:
+  - host: jump.example.com
+    userid: jumper
+    password: secret
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member := cfg.DiscoveredTarget(cfg.Targets[0], "192.0.2.2")
+	if member.IpAddress != "192.0.2.2" || member.Userid != "monitor" || member.Port != 2222 ||
+		member.CommandTimeout != 30*time.Second || len(member.ProxyJump) != 1 {
+		t.Errorf("expected the member to be reached like the seed, got %+v", member)
+	}
+	if member.Discover || member.CommandMode != CommandModeExec || !reflect.DeepEqual(member.Collectors, []string{"uptime"}) {
+		t.Errorf("expected the collection settings of the defaults, got %+v", member)
+	}
+	if !reflect.DeepEqual(member.Labels, map[string]string{"site": "fra"}) {
+		t.Errorf("expected the default labels only, got %v", member.Labels)
+	}
+	if member.HostKeyPolicy == HostKeyPolicyPinned || member.HostKeyFingerprint != "" {
+		t.Errorf("expected the pinned host key of the seed not to be passed on, got %s %s", member.HostKeyPolicy, member.HostKeyFingerprint)
+	}
+}
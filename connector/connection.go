@@ -2,11 +2,14 @@ package connector
 
 import (
 	"bytes"
+	"context"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
-	"github.com/prometheus/common/log"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -15,20 +18,48 @@ type SSHConnection struct {
 	host   string
 	client *ssh.Client
 	conn   net.Conn
-	mu     sync.Mutex
-	done   chan struct{}
+	config *ssh.ClientConfig
+	dial   dialFunc
+	mode   string
+	// timeout bounds the runtime of a single command
+	timeout time.Duration
+	shell   *shellSession
+	mu      sync.Mutex
+	done    chan struct{}
+
+	// pool bookkeeping, guarded by the mutex of the pool
+	pool *hostPool
+	// connected is cleared while the connection is lost and set again once it is re-established
+	connected    bool
+	inUse        int
+	lastUsed     time.Time
+	keepAliveRTT time.Duration
+	algorithms   NegotiatedAlgorithms
 }
 
-// RunCommand runs a command against the device
-func (c *SSHConnection) RunCommand(cmd string) (string, error) {
-	log.Debugf("Running command on %s:%s\n", c.host, cmd)
+// RunCommand runs a command against the device. It gives up once the context is done,
+// e.g. when the scrape timeout is reached.
+func (c *SSHConnection) RunCommand(ctx context.Context, cmd string) (string, error) {
+	level.Debug(logging.FromContext(ctx)).Log("msg", "Running command", "host", c.host, "command", cmd)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return "", errors.Wrapf(err, "Running command on %s:%s", c.host, cmd)
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
 	if c.client == nil {
 		return "", errors.Errorf("Running command on %s:%s: Not connected.", c.host, cmd)
 	}
 
+	if c.mode == CommandModeShell {
+		return c.runInShell(ctx, cmd)
+	}
+
 	session, err := c.client.NewSession()
 	if err != nil {
 		return "", errors.Wrapf(err, "Running command on %s:%s: Coud not open session.", c.host, cmd)
@@ -38,39 +69,89 @@ func (c *SSHConnection) RunCommand(cmd string) (string, error) {
 	var b = &bytes.Buffer{}
 	session.Stdout = b
 
-	err = session.Run(cmd)
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Closing the session makes the switch abort the command
+		session.Close()
+		return "", errors.Wrapf(ctx.Err(), "Running command on %s:%s", c.host, cmd)
+	}
 	if err != nil {
 		return "", errors.Wrapf(err, "Running command on %s:%s: Coud not run command.", c.host, cmd)
 	}
-	// log.Debugf("Output for %s:%s\n", c.host, string(b.Bytes()))
 	return string(b.Bytes()), nil
 }
 
+// runInShell runs a command in the interactive shell of the connection, which is opened on first use
+func (c *SSHConnection) runInShell(ctx context.Context, cmd string) (string, error) {
+	if c.shell == nil {
+		shell, err := openShell(ctx, c.client)
+		if err != nil {
+			return "", errors.Wrapf(err, "Running command on %s:%s: Coud not open shell.", c.host, cmd)
+		}
+		c.shell = shell
+	}
+
+	out, err := c.shell.run(ctx, cmd)
+	if err != nil {
+		// The output of the shell can't be matched to the commands anymore, start over with a new one
+		c.closeShell()
+		return "", errors.Wrapf(err, "Running command on %s:%s: Coud not run command.", c.host, cmd)
+	}
+	return out, nil
+}
+
+func (c *SSHConnection) closeShell() {
+	if c.shell != nil {
+		c.shell.close()
+		c.shell = nil
+	}
+}
+
+// isConnected tells whether the connection can run commands, the caller must hold the lock of the pool
 func (c *SSHConnection) isConnected() bool {
-	return c.conn != nil
+	return c.connected
+}
+
+// current returns the client and network connection, which are replaced on reconnect
+func (c *SSHConnection) current() (*ssh.Client, net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client, c.conn
 }
 
 func (c *SSHConnection) terminate() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.conn.Close()
+	c.closeShell()
+	if c.conn != nil {
+		c.conn.Close()
+	}
 
 	c.client = nil
 	c.conn = nil
 }
 
+// close closes the connection for good, the caller must hold the lock of the pool
 func (c *SSHConnection) close() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.closeShell()
 	if c.client != nil {
 		c.client.Close()
 	}
 
-	c.done <- struct{}{}
+	close(c.done)
 	c.conn = nil
 	c.client = nil
+	c.connected = false
 }
 
 // Host returns the hostname connected to
@@ -1,23 +1,56 @@
 package connector
 
 import (
-	"bytes"
-	"encoding/base64"
-	"io/ioutil"
+	"context"
+	"fmt"
 	"net"
-	"os"
-	"strings"
+	"reflect"
 	"sync"
 	"time"
 
-	"github.com/prometheus/common/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
 const timeoutInSeconds = 5
-const pubkeyDir = "/root/"
+const poolPrefix = "fabricos_exporter_ssh_"
+
+var (
+	poolConnectionsDesc = prometheus.NewDesc(poolPrefix+"pool_connections", "Number of open SSH connections in the pool.", []string{"target"}, nil)
+	poolInUseDesc       = prometheus.NewDesc(poolPrefix+"pool_connections_in_use", "Number of pooled SSH connections currently used by a scrape.", []string{"target"}, nil)
+	reconnectsDesc      = prometheus.NewDesc(poolPrefix+"reconnects_total", "Number of times a lost SSH connection was re-established.", []string{"target"}, nil)
+	keepAliveRTTDesc    = prometheus.NewDesc(poolPrefix+"keepalive_rtt_seconds", "Round-trip time of the last SSH keepalive request.", []string{"target"}, nil)
+	algorithmsInfoDesc  = prometheus.NewDesc(poolPrefix+"algorithms_info", "SSH algorithms negotiated with the device.", []string{"target", "kex", "host_key", "cipher", "mac"}, nil)
+	hostKeyFailuresDesc = prometheus.NewDesc(poolPrefix+"host_key_verification_failures_total", "Number of SSH connections rejected because the host key could not be verified.", []string{"target"}, nil)
+	connectFailuresDesc = prometheus.NewDesc(poolPrefix+"connect_failures_total", "Number of failed SSH connection attempts by the step which failed: dial, handshake, auth or host_key.", []string{"target", "reason"}, nil)
+)
+
+// Steps at which an SSH connection attempt fails
+const (
+	connectFailureDial      = "dial"
+	connectFailureHandshake = "handshake"
+	connectFailureAuth      = "auth"
+	connectFailureHostKey   = "host_key"
+)
+
+// connectFailure counts the failed connection attempts to a target
+type connectFailure struct {
+	target string
+	reason string
+}
+
+// hostKeyError is returned by the host key callback, so that host key failures can be told apart from other handshake failures
+type hostKeyError struct {
+	error
+}
+
+func (e hostKeyError) Unwrap() error {
+	return e.error
+}
 
 // Option defines options for the manager which are applied on creation
 type Option func(*SSHConnectionManager)
@@ -43,167 +76,653 @@ func WithKeepAliveTimeout(d time.Duration) Option {
 	}
 }
 
-// SSHConnectionManager manages SSH connections to different devices
+// WithIdleTimeout sets the time after which an unused connection is closed (default 5 minutes, 0 disables it)
+func WithIdleTimeout(d time.Duration) Option {
+	return func(m *SSHConnectionManager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithMaxConnectionsPerHost caps the number of connections opened to one device (default 1)
+func WithMaxConnectionsPerHost(n int) Option {
+	return func(m *SSHConnectionManager) {
+		m.maxConnectionsPerHost = n
+	}
+}
+
+// WithAuthMethods sets the order in which the authentication methods are tried (default publickey, agent, password, keyboard-interactive)
+func WithAuthMethods(methods []string) Option {
+	return func(m *SSHConnectionManager) {
+		m.authMethods = methods
+	}
+}
+
+// WithHostKeyStore sets the known_hosts file the host keys are verified against (default known_hosts in the working directory)
+func WithHostKeyStore(store *HostKeyStore) Option {
+	return func(m *SSHConnectionManager) {
+		m.hostKeys = store
+	}
+}
+
+// WithHostKeyPolicy sets the host key policy of targets which don't set one (default tofu)
+func WithHostKeyPolicy(policy string) Option {
+	return func(m *SSHConnectionManager) {
+		m.hostKeyPolicy = policy
+	}
+}
+
+// SSHConnectionManager manages SSH connections to different devices.
+// It is meant to be long living and shared by all scrapes, so connections are
+// kept open between scrapes and kept alive in the background.
 type SSHConnectionManager struct {
-	config            *ssh.ClientConfig
-	connections       map[string]*SSHConnection
-	reconnectInterval time.Duration
-	keepAliveInterval time.Duration
-	keepAliveTimeout  time.Duration
-	mu                sync.Mutex
-}
-
-// Store the remote public key of SAN Switch in the local file at the first time for later verfication
-func hostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	fn := pubkeyDir + "." + hostname + ".key"
-	if _, err := os.Stat(fn); err != nil {
-		if os.IsNotExist(err) {
-			// store the remote ssh hostkey
-			log.Debugf("Storing the remote hostkey to %s", fn)
-			return ioutil.WriteFile(fn, []byte(base64.StdEncoding.EncodeToString(key.Marshal())), 0600)
-		} else {
-			return errors.Wrapf(err, "error outside the os package", fn)
-		}
-	}
-	// read the stored pubkey of the remote host
-	f, err := ioutil.ReadFile(fn)
+	pools                 map[string]*hostPool
+	reconnectInterval     time.Duration
+	keepAliveInterval     time.Duration
+	keepAliveTimeout      time.Duration
+	idleTimeout           time.Duration
+	maxConnectionsPerHost int
+	authMethods           []string
+	hostKeys              *HostKeyStore
+	hostKeyPolicy         string
+	hostKeyFailures       map[string]float64
+	connectFailures       map[connectFailure]float64
+	jumps                 map[string]*jumpClient
+	jumpMu                sync.Mutex
+	done                  chan struct{}
+	mu                    sync.Mutex
+}
+
+// hostPool holds the connections to one device
+type hostPool struct {
+	target      string
+	config      Targets
+	connections []*SSHConnection
+	reconnects  float64
+	// removed is set once the target was removed from the configuration
+	removed bool
+	// adHoc pools belong to targets which are not configured, they are removed once they are idle
+	adHoc    bool
+	lastUsed time.Time
+	// dialing holds a token while a new connection is opened
+	dialing chan struct{}
+	mu      sync.Mutex
+}
+
+// NewConnectionManager creates a new connection manager
+func NewConnectionManager(opts ...Option) (*SSHConnectionManager, error) {
+	m := &SSHConnectionManager{
+		pools:                 make(map[string]*hostPool),
+		reconnectInterval:     30 * time.Second,
+		keepAliveInterval:     10 * time.Second,
+		keepAliveTimeout:      15 * time.Second,
+		idleTimeout:           5 * time.Minute,
+		maxConnectionsPerHost: 1,
+		authMethods:           DefaultAuthMethods,
+		hostKeys:              NewHostKeyStore("known_hosts"),
+		hostKeyPolicy:         HostKeyPolicyTOFU,
+		hostKeyFailures:       make(map[string]float64),
+		connectFailures:       make(map[connectFailure]float64),
+		jumps:                 make(map[string]*jumpClient),
+		done:                  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.maxConnectionsPerHost < 1 {
+		m.maxConnectionsPerHost = 1
+	}
+	if m.idleTimeout > 0 {
+		go m.closeIdleConnections()
+	}
+
+	return m, nil
+}
+
+// clientConfig builds the SSH client configuration for a target
+func (m *SSHConnectionManager) clientConfig(target Targets, host string) (*ssh.ClientConfig, error) {
+	auth, err := authMethods(target.Credentials, m.authMethods)
 	if err != nil {
-		return errors.Wrapf(err, "error reading the %s file", fn)
+		return nil, err
 	}
-	pubkeyStr, err := base64.StdEncoding.DecodeString(string(f))
+	policy := target.HostKeyPolicy
+	if policy == "" {
+		policy = m.hostKeyPolicy
+	}
+	verify, err := m.hostKeys.Callback(policy, target.HostKeyFingerprint)
 	if err != nil {
-		return errors.Wrap(err, "error decoding the local pubkey with base64")
+		return nil, err
 	}
-	pubkey, err := ssh.ParsePublicKey(pubkeyStr)
+	algorithms, err := resolveAlgorithms(target.Algorithms)
 	if err != nil {
-		return errors.Wrap(err, "error parsing to ssh.PublicKey")
+		return nil, err
 	}
-	if !bytes.Equal(pubkey.Marshal(), key.Marshal()) {
-		return errors.Wrap(err, "ssh: host key mismatch")
+	hostKeyAlgorithms := algorithms.HostKeyAlgorithms
+	if policy != HostKeyPolicyPinned {
+		// Prefer the key types already stored for the host, so that the key can be verified
+		var stored []string
+		for _, algorithm := range m.hostKeys.HostKeyAlgorithms(host) {
+			if contains(hostKeyAlgorithms, algorithm) {
+				stored = append(stored, algorithm)
+			}
+		}
+		if len(stored) > 0 {
+			hostKeyAlgorithms = stored
+		}
 	}
-	log.Debug("ssh: host key matched successfully")
-	return nil
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			Ciphers:      algorithms.Ciphers,
+			KeyExchanges: algorithms.KeyExchanges,
+			MACs:         algorithms.MACs,
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		User:              target.Userid,
+		Auth:              auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := verify(hostname, remote, key)
+			if err != nil {
+				level.Error(logging.With("target", target.IpAddress)).Log("msg", "Host key verification failed", "host", hostname, "err", err)
+				m.mu.Lock()
+				m.hostKeyFailures[target.IpAddress]++
+				m.mu.Unlock()
+				return hostKeyError{err}
+			}
+			return nil
+		},
+		Timeout: target.ConnectTimeout,
+	}, nil
 }
 
-// NewConnectionManager creates a new connection manager
-func NewConnectionManager(user string, passwd string, opts ...Option) (*SSHConnectionManager, error) {
-	// pk, err := loadPublicKeyFile(key)
-	// if err != nil {
-	// 	return nil, err
-	// }
+// Connect returns a pooled connection to a device, opening a new one if none is idle
+// and the per host limit is not reached yet. The connection has to be handed back
+// with Release once the caller is done with it. Connecting, and waiting for another
+// caller which is connecting to the same device, is aborted once the context is done.
+func (m *SSHConnectionManager) Connect(ctx context.Context, target Targets) (*SSHConnection, error) {
+	key, host := poolKey(target)
+
+	for {
+		m.mu.Lock()
+		pool, found := m.pools[key]
+		if !found {
+			pool = &hostPool{target: target.IpAddress, config: target, adHoc: target.AdHoc, dialing: make(chan struct{}, 1)}
+			m.pools[key] = pool
+		}
+		m.mu.Unlock()
+
+		if connection, ok, err := m.pooled(pool); ok {
+			return connection, err
+		}
 
-	cfg := &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(passwd)},
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         timeoutInSeconds * time.Second,
+		// Only one connection to a device is opened at a time. The pool isn't locked while dialing,
+		// so callers which can share a connection and the idle handling don't wait for a hung switch.
+		select {
+		case pool.dialing <- struct{}{}:
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "another connection to the device is being opened")
+		}
+		connection, retry, err := m.open(ctx, pool, host, target)
+		<-pool.dialing
+		if !retry {
+			return connection, err
+		}
 	}
+}
 
-	m := &SSHConnectionManager{
-		config:            cfg,
-		connections:       make(map[string]*SSHConnection),
-		reconnectInterval: 30 * time.Second,
-		keepAliveInterval: 10 * time.Second,
-		keepAliveTimeout:  15 * time.Second,
+// pooled returns an idle connection of the pool, or the least used one once the per host limit
+// is reached. It returns false if a new connection should be opened.
+func (m *SSHConnectionManager) pooled(pool *hostPool) (*SSHConnection, bool, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.lastUsed = time.Now()
+
+	var shared *SSHConnection
+	for _, connection := range pool.connections {
+		if !connection.isConnected() {
+			continue
+		}
+		if connection.inUse == 0 {
+			return m.acquire(connection), true, nil
+		}
+		if shared == nil || connection.inUse < shared.inUse {
+			shared = connection
+		}
 	}
+	if len(pool.connections) < m.maxConnectionsPerHost {
+		return nil, false, nil
+	}
+	if shared == nil {
+		return nil, true, errors.New("not connected")
+	}
+	return m.acquire(shared), true, nil
+}
 
-	for _, opt := range opts {
-		opt(m)
+// open opens a new connection to the device of the pool, the caller must hold the dialing slot of the pool.
+// It returns true if the pool was removed while waiting for the slot and the lookup has to be repeated.
+func (m *SSHConnectionManager) open(ctx context.Context, pool *hostPool, host string, target Targets) (*SSHConnection, bool, error) {
+	pool.mu.Lock()
+	removed := pool.removed
+	pool.mu.Unlock()
+	if removed {
+		return nil, true, nil
+	}
+	// Another caller may have connected while this one was waiting
+	if connection, ok, err := m.pooled(pool); ok {
+		return connection, false, err
 	}
 
-	return m, nil
+	config, err := m.clientConfig(target, host)
+	if err != nil {
+		return nil, false, err
+	}
+	dial, err := m.dialer(target)
+	if err != nil {
+		return nil, false, err
+	}
+	connection, err := m.connect(ctx, pool, host, config, dial, target)
+	if isAuthError(err) && target.forgetSecrets() {
+		// The password may have been rotated, read it again and retry once
+		level.Info(logging.FromContext(ctx)).Log("msg", "Authentication failed, reading the password again", "target", target.IpAddress)
+		connection, err = m.connect(ctx, pool, host, config, dial, target)
+	}
+	return connection, false, err
+}
+
+// poolKey returns the key of the pool of a target and the canonical address of the device
+func poolKey(target Targets) (string, string) {
+	host, err := CanonicalAddress(target.IpAddress, target.Port)
+	if err != nil {
+		// The address was validated with the configuration, connecting reports the error
+		host = target.IpAddress
+	}
+	return target.Userid + "@" + host, host
 }
 
-// Connect connects to a device or returns an long living connection
-func (m *SSHConnectionManager) Connect(host string) (*SSHConnection, error) {
-	if !strings.Contains(host, ":") {
-		host = host + ":22"
+// Retain closes the connections to targets which are no longer configured or whose settings changed.
+// Connections in use by a scrape are closed once they are released. The connections to ad-hoc targets
+// are kept until they are idle.
+func (m *SSHConnectionManager) Retain(targets []Targets) {
+	configured := make(map[string]Targets)
+	for _, target := range targets {
+		key, _ := poolKey(target)
+		configured[key] = target
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if connection, found := m.connections[host]; found {
-		if !connection.isConnected() {
-			return nil, errors.New("not connected")
+	for key, pool := range m.pools {
+		target, found := configured[key]
+		if found && reflect.DeepEqual(target.connectionSettings(), pool.config.connectionSettings()) {
+			continue
 		}
-
-		return connection, nil
+		if !found && pool.adHoc {
+			continue
+		}
+		level.Info(logging.With("target", pool.target)).Log("msg", "Closing the connections, the target was removed or changed")
+		pool.mu.Lock()
+		pool.removed = true
+		var inUse []*SSHConnection
+		for _, connection := range pool.connections {
+			if connection.inUse > 0 {
+				inUse = append(inUse, connection)
+				continue
+			}
+			connection.close()
+		}
+		pool.connections = inUse
+		pool.mu.Unlock()
+		delete(m.pools, key)
 	}
+}
+
+// connectionSettings returns the target without the settings which only affect the collection
+func (t Targets) connectionSettings() Targets {
+	t.Collectors = nil
+	t.Labels = nil
+	t.FullMetrics = nil
+	t.PollInterval = nil
+	t.Discover = false
+	return t
+}
+
+func (m *SSHConnectionManager) acquire(connection *SSHConnection) *SSHConnection {
+	connection.inUse++
+	connection.lastUsed = time.Now()
+	return connection
+}
 
-	return m.connect(host)
+// Release hands a connection obtained by Connect back to the pool
+func (m *SSHConnectionManager) Release(connection *SSHConnection) {
+	connection.pool.mu.Lock()
+	defer connection.pool.mu.Unlock()
+
+	connection.inUse--
+	connection.lastUsed = time.Now()
+	if connection.pool.removed && connection.inUse == 0 {
+		connection.close()
+	}
 }
 
-func (m *SSHConnectionManager) connect(host string) (*SSHConnection, error) {
-	client, conn, err := m.connectToServer(host)
+// connect opens a connection and adds it to the pool, acquired for the caller
+func (m *SSHConnectionManager) connect(ctx context.Context, pool *hostPool, host string, config *ssh.ClientConfig, dial dialFunc, target Targets) (*SSHConnection, error) {
+	client, conn, algorithms, err := m.connectToServer(ctx, pool, host, config, dial)
 	if err != nil {
 		return nil, err
 	}
 
 	c := &SSHConnection{
-		conn:   conn,
-		client: client,
-		host:   host,
-		done:   make(chan struct{}),
+		conn:       conn,
+		client:     client,
+		algorithms: algorithms,
+		host:       host,
+		config:     config,
+		dial:       dial,
+		mode:       target.CommandMode,
+		timeout:    target.CommandTimeout,
+		pool:       pool,
+		connected:  true,
+		done:       make(chan struct{}),
 	}
 	go m.keepAlive(c)
 
-	m.connections[host] = c
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	// If the target was removed meanwhile, the connection is closed once it is released
+	pool.connections = append(pool.connections, c)
 
-	return c, nil
+	return m.acquire(c), nil
 }
 
-func (m *SSHConnectionManager) connectToServer(host string) (*ssh.Client, net.Conn, error) {
-	conn, err := net.DialTimeout("tcp", host, m.config.Timeout)
+func (m *SSHConnectionManager) connectToServer(ctx context.Context, pool *hostPool, host string, config *ssh.ClientConfig, dial dialFunc) (*ssh.Client, net.Conn, NegotiatedAlgorithms, error) {
+	tcpConn, err := dial("tcp", host)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "could not open tcp connection")
+		m.connectFailed(pool.target, connectFailureDial)
+		return nil, nil, NegotiatedAlgorithms{}, errors.Wrap(err, "could not open tcp connection")
 	}
+	conn := &kexInitConn{Conn: tcpConn}
 
-	c, chans, reqs, err := ssh.NewClientConn(conn, host, m.config)
+	c, chans, reqs, err := handshake(ctx, conn, host, config)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "could not connect to device")
+		var keyErr hostKeyError
+		switch {
+		case errors.As(err, &keyErr):
+			m.connectFailed(pool.target, connectFailureHostKey)
+		case isAuthError(err):
+			m.connectFailed(pool.target, connectFailureAuth)
+		default:
+			m.connectFailed(pool.target, connectFailureHandshake)
+		}
+		return nil, nil, NegotiatedAlgorithms{}, errors.Wrap(err, "could not connect to device")
 	}
 
-	return ssh.NewClient(c, chans, reqs), conn, nil
+	var algorithms NegotiatedAlgorithms
+	if server := conn.serverKexInit(); server != nil {
+		algorithms = negotiate(config, server)
+		level.Debug(logging.With("target", pool.target)).Log("msg", "Negotiated SSH algorithms", "host", host, "algorithms", fmt.Sprintf("%+v", algorithms))
+	}
+	return ssh.NewClient(c, chans, reqs), conn, algorithms, nil
+}
+
+// forget drops the failure counters of a target, the caller must hold the lock of the manager
+func (m *SSHConnectionManager) forget(target string) {
+	delete(m.hostKeyFailures, target)
+	for failure := range m.connectFailures {
+		if failure.target == target {
+			delete(m.connectFailures, failure)
+		}
+	}
+}
+
+// connectFailed counts a failed connection attempt
+func (m *SSHConnectionManager) connectFailed(target string, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connectFailures[connectFailure{target, reason}]++
+}
+
+// handshake runs the SSH handshake on conn. As tunneled connections don't support deadlines,
+// the connection is closed to abort the handshake when the context is done or the connect timeout
+// of the config is reached. The connection is closed if the handshake fails.
+func handshake(ctx context.Context, conn net.Conn, host string, config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = timeoutInSeconds * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	finished := make(chan struct{})
+	aborted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			aborted <- true
+		case <-finished:
+			aborted <- false
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+	close(finished)
+	if <-aborted {
+		if c != nil {
+			c.Close()
+		}
+		return nil, nil, nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return c, chans, reqs, nil
 }
 
 func (m *SSHConnectionManager) keepAlive(connection *SSHConnection) {
 	for {
 		select {
 		case <-time.After(m.keepAliveInterval):
-			log.Debugf("Sending keepalive for ")
-			connection.conn.SetDeadline(time.Now().Add(m.keepAliveTimeout))
-			_, _, err := connection.client.SendRequest("keepalive@golang.org", true, nil)
+			level.Debug(logging.With("target", connection.pool.target)).Log("msg", "Sending keepalive", "host", connection.Host())
+			client, conn := connection.current()
+			if client == nil {
+				return
+			}
+			// Tunneled connections don't support deadlines, a hung connection is closed instead
+			start := time.Now()
+			timer := time.AfterFunc(m.keepAliveTimeout, func() { conn.Close() })
+			_, _, err := client.SendRequest("keepalive@golang.org", true, nil)
+			timer.Stop()
 			if err != nil {
-				log.Infof("Lost connection to %s (%v). Trying to reconnect...", connection.Host(), err)
+				level.Info(logging.With("target", connection.pool.target)).Log("msg", "Lost connection. Trying to reconnect...", "host", connection.Host(), "err", err)
+				connection.pool.mu.Lock()
+				connection.connected = false
+				connection.pool.mu.Unlock()
 				connection.terminate()
-				m.reconnect(connection)
+				if !m.reconnect(connection) {
+					return
+				}
+				continue
 			}
+			connection.pool.mu.Lock()
+			connection.keepAliveRTT = time.Since(start)
+			connection.pool.mu.Unlock()
 		case <-connection.done:
 			return
 		}
 	}
 }
 
-func (m *SSHConnectionManager) reconnect(connection *SSHConnection) {
+// reconnect re-establishes a lost connection. It returns false if the connection
+// was closed while reconnecting.
+func (m *SSHConnectionManager) reconnect(connection *SSHConnection) bool {
 	for {
-		client, conn, err := m.connectToServer(connection.Host())
+		client, conn, algorithms, err := m.connectToServer(context.Background(), connection.pool, connection.Host(), connection.config, connection.dial)
 		if err == nil {
+			connection.pool.mu.Lock()
+			defer connection.pool.mu.Unlock()
+			select {
+			case <-connection.done:
+				// The connection was closed while reconnecting
+				client.Close()
+				return false
+			default:
+			}
+			connection.mu.Lock()
 			connection.client = client
 			connection.conn = conn
+			connection.mu.Unlock()
+			connection.algorithms = algorithms
+			connection.connected = true
+			connection.pool.reconnects++
+			return true
+		}
+
+		level.Info(logging.With("target", connection.pool.target)).Log("msg", "Reconnect failed", "host", connection.Host(), "err", err)
+		if isAuthError(err) {
+			connection.pool.config.forgetSecrets()
+		}
+		select {
+		case <-time.After(m.reconnectInterval):
+		case <-connection.done:
+			return false
+		}
+	}
+}
+
+// closeIdleConnections periodically closes connections which were not used within the idle timeout.
+// The pools of ad-hoc targets are removed together with their metrics once they have no connection left.
+func (m *SSHConnectionManager) closeIdleConnections() {
+	interval := m.idleTimeout / 2
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	for {
+		select {
+		case <-time.After(interval):
+			m.mu.Lock()
+			for key, pool := range m.pools {
+				pool.mu.Lock()
+				var open []*SSHConnection
+				for _, connection := range pool.connections {
+					if connection.inUse == 0 && time.Since(connection.lastUsed) > m.idleTimeout {
+						level.Debug(logging.With("target", pool.target)).Log("msg", "Closing idle connection", "host", connection.Host())
+						connection.close()
+						continue
+					}
+					open = append(open, connection)
+				}
+				pool.connections = open
+				if pool.adHoc && len(open) == 0 && len(pool.dialing) == 0 && time.Since(pool.lastUsed) > m.idleTimeout {
+					level.Debug(logging.With("target", pool.target)).Log("msg", "Removing the idle pool of the ad-hoc target")
+					pool.removed = true
+					delete(m.pools, key)
+					m.forget(pool.target)
+				}
+				pool.mu.Unlock()
+			}
+			m.mu.Unlock()
+			m.closeIdleJumps()
+		case <-m.done:
 			return
 		}
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (m *SSHConnectionManager) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnectionsDesc
+	ch <- poolInUseDesc
+	ch <- reconnectsDesc
+	ch <- keepAliveRTTDesc
+	ch <- algorithmsInfoDesc
+	ch <- hostKeyFailuresDesc
+	ch <- connectFailuresDesc
+}
 
-		log.Infof("Reconnect to %s failed: %v", connection.Host(), err)
-		time.Sleep(m.reconnectInterval)
+// Collect implements the prometheus.Collector interface.
+func (m *SSHConnectionManager) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pool := range m.pools {
+		status := pool.status()
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, float64(status.Connections), pool.target)
+		ch <- prometheus.MustNewConstMetric(poolInUseDesc, prometheus.GaugeValue, float64(status.InUse), pool.target)
+		ch <- prometheus.MustNewConstMetric(reconnectsDesc, prometheus.CounterValue, status.Reconnects, pool.target)
+		ch <- prometheus.MustNewConstMetric(keepAliveRTTDesc, prometheus.GaugeValue, status.KeepAliveRTT.Seconds(), pool.target)
+		if algorithms := status.Algorithms; algorithms.KeyExchange != "" {
+			ch <- prometheus.MustNewConstMetric(algorithmsInfoDesc, prometheus.GaugeValue, 1, pool.target,
+				algorithms.KeyExchange, algorithms.HostKey, algorithms.Cipher, algorithms.MAC)
+		}
 	}
+	for target, failures := range m.hostKeyFailures {
+		ch <- prometheus.MustNewConstMetric(hostKeyFailuresDesc, prometheus.CounterValue, failures, target)
+	}
+	for failure, count := range m.connectFailures {
+		ch <- prometheus.MustNewConstMetric(connectFailuresDesc, prometheus.CounterValue, count, failure.target, failure.reason)
+	}
+}
+
+// PoolStatus describes the pooled connections to a target
+type PoolStatus struct {
+	// Connections is the number of open connections
+	Connections  int
+	InUse        int
+	Reconnects   float64
+	KeepAliveRTT time.Duration
+	Algorithms   NegotiatedAlgorithms
+}
+
+// PoolStatus returns the state of the pooled connections to a target, false if it was never connected
+func (m *SSHConnectionManager) PoolStatus(target Targets) (PoolStatus, bool) {
+	key, _ := poolKey(target)
+	m.mu.Lock()
+	pool, found := m.pools[key]
+	m.mu.Unlock()
+	if !found {
+		return PoolStatus{}, false
+	}
+	return pool.status(), true
+}
+
+func (pool *hostPool) status() PoolStatus {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	status := PoolStatus{Reconnects: pool.reconnects}
+	for _, connection := range pool.connections {
+		if connection.isConnected() {
+			if status.Connections == 0 {
+				status.Algorithms = connection.algorithms
+			}
+			status.Connections++
+		}
+		if connection.inUse > 0 {
+			status.InUse++
+		}
+		if connection.keepAliveRTT > status.KeepAliveRTT {
+			status.KeepAliveRTT = connection.keepAliveRTT
+		}
+	}
+	return status
 }
 
 // Close closes all TCP connections and stop keep alives
 func (m *SSHConnectionManager) Close() error {
-	for _, c := range m.connections {
-		c.close()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pool := range m.pools {
+		pool.mu.Lock()
+		for _, c := range pool.connections {
+			c.close()
+		}
+		pool.connections = nil
+		pool.mu.Unlock()
 	}
+	close(m.done)
+	m.closeJumps()
 
 	return nil
 }
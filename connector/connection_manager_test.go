@@ -0,0 +1,374 @@
+package connector
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func poolOf(m *SSHConnectionManager, target Targets) *hostPool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pools[target.Userid+"@"+target.IpAddress]
+}
+
+func inUseOf(c *SSHConnection) int {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+
+	return c.inUse
+}
+
+func TestConnectReusesConnection(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	first, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := first.RunCommand(context.Background(), "uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != s.outputs["uptime"] {
+		t.Errorf("unexpected output %q", out)
+	}
+	m.Release(first)
+
+	second, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(second)
+	if second != first {
+		t.Error("expected the idle connection to be reused")
+	}
+	if n := s.loginCount(); n != 1 {
+		t.Errorf("expected 1 login, got %d", n)
+	}
+}
+
+func TestConnectCountsUsers(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s, WithMaxConnectionsPerHost(2))
+	defer m.Close()
+
+	first, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("expected a second connection while the first one is in use")
+	}
+
+	// the limit is reached, so the least used connection is shared
+	third, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != first && third != second {
+		t.Fatal("expected an existing connection to be shared")
+	}
+	if n := inUseOf(third); n != 2 {
+		t.Errorf("expected the shared connection to be used twice, got %d", n)
+	}
+	if n := s.loginCount(); n != 2 {
+		t.Errorf("expected 2 logins, got %d", n)
+	}
+
+	m.Release(third)
+	m.Release(second)
+	m.Release(first)
+	for _, c := range []*SSHConnection{first, second} {
+		if n := inUseOf(c); n != 0 {
+			t.Errorf("expected all connections to be released, got %d", n)
+		}
+	}
+
+	// a released connection is handed out again before the shared one
+	fourth, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(fourth)
+	if n := inUseOf(fourth); n != 1 {
+		t.Errorf("expected an idle connection, got one used %d times", n)
+	}
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s, WithIdleTimeout(50*time.Millisecond))
+	defer m.Close()
+
+	c, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := poolOf(m, s.target())
+	open := func() int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.connections)
+	}
+
+	// connections in use are never closed
+	time.Sleep(150 * time.Millisecond)
+	if open() != 1 {
+		t.Fatal("expected the connection in use to stay open")
+	}
+
+	m.Release(c)
+	waitFor(t, 2*time.Second, func() bool { return open() == 0 })
+
+	c, err = m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Release(c)
+	if n := s.loginCount(); n != 2 {
+		t.Errorf("expected a new login after the idle connection was closed, got %d logins", n)
+	}
+}
+
+func TestReconnectAfterKeepAliveFailure(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s,
+		WithKeepAliveInterval(20*time.Millisecond),
+		WithKeepAliveTimeout(time.Second),
+		WithReconnectInterval(20*time.Millisecond),
+	)
+	defer m.Close()
+
+	c, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+	pool := poolOf(m, s.target())
+
+	s.dropConnections()
+	waitFor(t, 2*time.Second, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.reconnects == 1
+	})
+
+	if _, err := c.RunCommand(context.Background(), "uptime"); err != nil {
+		t.Fatalf("expected the connection to be usable after reconnecting: %v", err)
+	}
+	if n := s.loginCount(); n != 2 {
+		t.Errorf("expected 2 logins, got %d", n)
+	}
+}
+
+func TestRunCommandInShell(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.CommandMode = CommandModeShell
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+
+	for i := 0; i < 2; i++ {
+		out, err := c.RunCommand(context.Background(), "uptime")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := strings.TrimSuffix(s.outputs["uptime"], "\n"); out != expected {
+			t.Errorf("RunCommand() = %q, want %q", out, expected)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shells != 1 {
+		t.Errorf("expected the commands to share one shell, got %d shells", s.shells)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	kept := newFakeSwitch(t)
+	defer kept.close()
+	changed := newFakeSwitch(t)
+	defer changed.close()
+	removed := newFakeSwitch(t)
+	defer removed.close()
+	m := newTestManager(kept)
+	defer m.Close()
+
+	connections := make(map[*fakeSwitch]*SSHConnection)
+	for _, s := range []*fakeSwitch{kept, changed, removed} {
+		c, err := m.Connect(context.Background(), s.target())
+		if err != nil {
+			t.Fatal(err)
+		}
+		connections[s] = c
+	}
+	m.Release(connections[kept])
+	m.Release(connections[changed])
+
+	changedTarget := changed.target()
+	changedTarget.CommandMode = CommandModeShell
+	// labels and collectors don't affect the connection
+	keptTarget := kept.target()
+	keptTarget.Labels = map[string]string{"site": "fra"}
+	keptTarget.Collectors = []string{"uptime"}
+	m.Retain([]Targets{keptTarget, changedTarget})
+
+	if poolOf(m, kept.target()) == nil {
+		t.Error("expected the pool of the unchanged target to be kept")
+	}
+	if poolOf(m, changed.target()) != nil || poolOf(m, removed.target()) != nil {
+		t.Error("expected the pools of the changed and removed targets to be dropped")
+	}
+	if _, err := connections[changed].RunCommand(context.Background(), "uptime"); err == nil {
+		t.Error("expected the idle connection to the changed target to be closed")
+	}
+
+	// the connection in use is closed once the scrape is done with it
+	c := connections[removed]
+	if _, err := c.RunCommand(context.Background(), "uptime"); err != nil {
+		t.Errorf("expected the connection in use to stay open: %v", err)
+	}
+	m.Release(c)
+	if _, err := c.RunCommand(context.Background(), "uptime"); err == nil {
+		t.Error("expected the connection to be closed once released")
+	}
+
+	// the next scrape of the changed target connects with the new settings
+	c, err := m.Connect(context.Background(), changedTarget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+	if c == connections[changed] || c.mode != CommandModeShell {
+		t.Error("expected a new connection with the changed settings")
+	}
+}
+
+func TestPoolStatus(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s, WithMaxConnectionsPerHost(2))
+	defer m.Close()
+
+	if _, found := m.PoolStatus(s.target()); found {
+		t.Error("expected no status before the first connection")
+	}
+	first, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Release(second)
+
+	status, found := m.PoolStatus(s.target())
+	if !found {
+		t.Fatal("expected the status of the pool")
+	}
+	if status.Connections != 2 || status.InUse != 1 || status.Reconnects != 0 {
+		t.Errorf("expected 2 connections with 1 in use, got %+v", status)
+	}
+	if status.Algorithms.KeyExchange == "" || status.Algorithms.Cipher == "" {
+		t.Errorf("expected the negotiated algorithms, got %+v", status.Algorithms)
+	}
+	m.Release(first)
+}
+
+func TestConnectWhileDialing(t *testing.T) {
+	// a device which accepts the TCP connection but never starts the SSH handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s, WithMaxConnectionsPerHost(2))
+	defer m.Close()
+
+	target := s.target()
+	target.IpAddress = l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Connect(ctx, target)
+		done <- err
+	}()
+	waitFor(t, 2*time.Second, func() bool {
+		pool := poolOf(m, target)
+		return pool != nil && len(pool.dialing) == 1
+	})
+
+	// the pool isn't locked while dialing
+	status := make(chan PoolStatus, 1)
+	go func() {
+		s, _ := m.PoolStatus(target)
+		status <- s
+	}()
+	select {
+	case s := <-status:
+		if s.Connections != 0 {
+			t.Errorf("expected no connections, got %+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("PoolStatus() blocked while a connection was being opened")
+	}
+
+	// waiting for the other caller is aborted once the context is done
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	start := time.Now()
+	if _, err := m.Connect(waitCtx, target); err == nil {
+		t.Error("expected an error while another connection is being opened")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Connect() returned %v after the deadline", d)
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("expected the hung connection to fail")
+	}
+	if pool := poolOf(m, target); len(pool.dialing) != 0 {
+		t.Error("expected the dialing slot to be released")
+	}
+}
@@ -0,0 +1,128 @@
+package connector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunCommandCancelled(t *testing.T) {
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.hang = "supportsave"
+	})
+	defer s.close()
+
+	for _, mode := range []string{CommandModeExec, CommandModeShell} {
+		m := newTestManager(s)
+		target := s.target()
+		target.CommandMode = mode
+		c, err := m.Connect(context.Background(), target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		_, err = c.RunCommand(ctx, "supportsave")
+		cancel()
+		if err == nil {
+			t.Errorf("%s: expected the hung command to fail", mode)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: the command returned %v after the deadline", mode, d)
+		}
+
+		// the connection can still be used by the next scrape
+		if _, err := c.RunCommand(context.Background(), "uptime"); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+		m.Release(c)
+		m.Close()
+	}
+}
+
+func TestRunCommandContextDone(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	c, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.RunCommand(ctx, "uptime"); err == nil {
+		t.Error("expected no command to be run once the context is done")
+	}
+}
+
+func TestConnectHandshakeCancelled(t *testing.T) {
+	// a device which accepts the TCP connection but never starts the SSH handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.IpAddress = l.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := m.Connect(ctx, target); err == nil {
+		t.Fatal("expected the handshake to be aborted")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Connect() returned %v after the deadline", d)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.hang = "supportsave"
+	})
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.CommandTimeout = 100 * time.Millisecond
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+
+	start := time.Now()
+	if _, err := c.RunCommand(context.Background(), "supportsave"); err == nil {
+		t.Error("expected the command to time out")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the command returned %v after the timeout", d)
+	}
+}
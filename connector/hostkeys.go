@@ -0,0 +1,256 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies which can be set per target
+const (
+	// HostKeyPolicyStrict only accepts host keys which are already in the known_hosts file
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyTOFU stores the key of an unknown host on first use and rejects changed keys afterwards
+	HostKeyPolicyTOFU = "tofu"
+	// HostKeyPolicyPinned only accepts the key matching the configured hostKeyFingerprint
+	HostKeyPolicyPinned = "pinned"
+)
+
+// KnownHost is one entry of the known_hosts file
+type KnownHost struct {
+	Hosts       []string
+	KeyType     string
+	Fingerprint string
+}
+
+// HostKeyStore verifies host keys against a known_hosts file in OpenSSH format
+type HostKeyStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewHostKeyStore creates a store backed by the known_hosts file at path.
+// The file is created when the first key is trusted.
+func NewHostKeyStore(path string) *HostKeyStore {
+	return &HostKeyStore{path: path}
+}
+
+// Callback returns the host key callback implementing the given policy
+func (s *HostKeyStore) Callback(policy string, fingerprint string) (ssh.HostKeyCallback, error) {
+	switch policy {
+	case HostKeyPolicyPinned:
+		if fingerprint == "" {
+			return nil, errors.New("host key policy pinned requires hostKeyFingerprint")
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != fingerprint && ssh.FingerprintLegacyMD5(key) != strings.TrimPrefix(fingerprint, "MD5:") {
+				return errors.Errorf("ssh: host key mismatch for %s: got %s, pinned %s", hostname, ssh.FingerprintSHA256(key), fingerprint)
+			}
+			return nil
+		}, nil
+	case HostKeyPolicyStrict, HostKeyPolicyTOFU:
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return s.verify(policy, hostname, remote, key)
+		}, nil
+	}
+	return nil, errors.Errorf("unknown host key policy %q", policy)
+}
+
+func (s *HostKeyStore) verify(policy string, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.check(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if err == nil {
+		level.Debug(logging.Base()).Log("msg", "ssh: host key matched successfully", "host", hostname)
+		return nil
+	}
+	if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 || policy != HostKeyPolicyTOFU {
+		return err
+	}
+
+	// The host is unknown, trust it on first use
+	level.Info(logging.Base()).Log("msg", "Storing the host key", "fingerprint", ssh.FingerprintSHA256(key), "host", hostname, "file", s.path)
+	return s.add(hostname, key)
+}
+
+// check verifies the key against the current content of the known_hosts file
+func (s *HostKeyStore) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+	callback, err := knownhosts.New(s.path)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the %s file", s.path)
+	}
+	return callback(hostname, remote, key)
+}
+
+// HostKeyAlgorithms returns the algorithms of the keys stored for a host, so that the
+// handshake negotiates a key type which can be verified
+func (s *HostKeyStore) HostKeyAlgorithms(host string) []string {
+	entries, err := s.List()
+	if err != nil {
+		return nil
+	}
+	host = knownhosts.Normalize(host)
+	var algorithms []string
+	for _, entry := range entries {
+		if !contains(entry.Hosts, host) {
+			continue
+		}
+		if entry.KeyType == ssh.KeyAlgoRSA {
+			// RSA keys are also used with the SHA-2 signature algorithms
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, entry.KeyType)
+	}
+	return algorithms
+}
+
+// Add trusts the key of a host, replacing keys of the same type already stored for it
+func (s *HostKeyStore) Add(host string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.remove(host, key.Type()); err != nil {
+		return err
+	}
+	return s.add(host, key)
+}
+
+func (s *HostKeyStore) add(host string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return errors.Wrapf(err, "error creating the directory of %s", s.path)
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "error opening the %s file", s.path)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(host)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return errors.Wrapf(err, "error writing the %s file", s.path)
+	}
+	return nil
+}
+
+// Remove forgets all keys of a host and returns the number of removed entries
+func (s *HostKeyStore) Remove(host string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remove(host, "")
+}
+
+// remove drops the entries of a host, only those of the given key type if it is not empty
+func (s *HostKeyStore) remove(host string, keyType string) (int, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading the %s file", s.path)
+	}
+
+	host = knownhosts.Normalize(host)
+	removed := 0
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		_, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err == nil && contains(hosts, host) && (keyType == "" || key.Type() == keyType) {
+			removed++
+			continue
+		}
+		kept.WriteString(line + "\n")
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := ioutil.WriteFile(s.path, kept.Bytes(), 0600); err != nil {
+		return 0, errors.Wrapf(err, "error writing the %s file", s.path)
+	}
+	return removed, nil
+}
+
+// List returns the entries of the known_hosts file
+func (s *HostKeyStore) List() ([]KnownHost, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the %s file", s.path)
+	}
+
+	var entries []KnownHost
+	for len(content) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(content)
+		if err != nil {
+			break
+		}
+		entries = append(entries, KnownHost{
+			Hosts:       hosts,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+		})
+		content = rest
+	}
+	return entries, nil
+}
+
+// FetchHostKey connects to a target and returns the host key it presents, without logging in.
+// The target is reached through its proxies and jump hosts, and the key types are negotiated
+// as for a scrape, so that the stored key of the same type is the one replaced.
+func (m *SSHConnectionManager) FetchHostKey(target Targets) (ssh.PublicKey, error) {
+	_, host := poolKey(target)
+	config, err := m.clientConfig(target, host)
+	if err != nil {
+		return nil, err
+	}
+	dial, err := m.dialer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostKey ssh.PublicKey
+	errFetched := errors.New("host key fetched")
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key
+		return errFetched
+	}
+	if config.Timeout == 0 {
+		config.Timeout = timeoutInSeconds * time.Second
+	}
+	conn, err := dial("tcp", host)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open tcp connection")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+
+	_, _, _, err = ssh.NewClientConn(conn, host, config)
+	if hostKey == nil {
+		return nil, errors.Wrap(err, "could not fetch the host key")
+	}
+	return hostKey, nil
+}
@@ -0,0 +1,271 @@
+package connector
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func hostKeyFailures(m *SSHConnectionManager, target string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.hostKeyFailures[target]
+}
+
+func TestHostKeyPolicyTOFU(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	c, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Release(c)
+
+	entries, err := s.hostKeys.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ssh.FingerprintSHA256(testSigner(t, "host").PublicKey())
+	if len(entries) != 1 || entries[0].Fingerprint != expected {
+		t.Fatalf("expected the host key %s to be stored on first use, got %+v", expected, entries)
+	}
+
+	// a changed key is rejected once the host is known
+	if err := s.hostKeys.Add(s.addr(), testSigner(t, "other").PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	m2 := newTestManager(s)
+	defer m2.Close()
+	if _, err := m2.Connect(context.Background(), s.target()); err == nil {
+		t.Fatal("expected a changed host key to be rejected")
+	}
+	if n := hostKeyFailures(m2, s.addr()); n != 1 {
+		t.Errorf("expected 1 host key verification failure, got %v", n)
+	}
+}
+
+func TestHostKeyPolicyStrict(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s, WithHostKeyPolicy(HostKeyPolicyStrict))
+	defer m.Close()
+
+	if _, err := m.Connect(context.Background(), s.target()); err == nil {
+		t.Fatal("expected an unknown host to be rejected")
+	}
+	if entries, _ := s.hostKeys.List(); len(entries) != 0 {
+		t.Fatalf("expected no host key to be stored, got %+v", entries)
+	}
+
+	if err := s.hostKeys.Add(s.addr(), testSigner(t, "host").PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.Connect(context.Background(), s.target())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Release(c)
+}
+
+func TestHostKeyPolicyPinned(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+
+	tests := []struct {
+		fingerprint string
+		fails       bool
+	}{
+		{ssh.FingerprintSHA256(testSigner(t, "host").PublicKey()), false},
+		{"MD5:" + ssh.FingerprintLegacyMD5(testSigner(t, "host").PublicKey()), false},
+		{ssh.FingerprintSHA256(testSigner(t, "other").PublicKey()), true},
+		{"", true},
+	}
+	for _, test := range tests {
+		m := newTestManager(s)
+		target := s.target()
+		target.HostKeyPolicy = HostKeyPolicyPinned
+		target.HostKeyFingerprint = test.fingerprint
+		c, err := m.Connect(context.Background(), target)
+		if test.fails {
+			if err == nil {
+				t.Errorf("fingerprint %q: expected an error", test.fingerprint)
+				m.Release(c)
+			}
+		} else if err != nil {
+			t.Errorf("fingerprint %q: %v", test.fingerprint, err)
+		} else {
+			m.Release(c)
+		}
+		m.Close()
+	}
+	if entries, _ := s.hostKeys.List(); len(entries) != 0 {
+		t.Errorf("expected pinned keys not to be stored, got %+v", entries)
+	}
+}
+
+func TestHostKeyStore(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	store := s.hostKeys
+	rsaKey := testSigner(t, "host").PublicKey()
+
+	if err := store.Add("switch1", rsaKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("switch2:2222", rsaKey); err != nil {
+		t.Fatal(err)
+	}
+	// a key of the same type replaces the stored one
+	if err := store.Add("switch1", testSigner(t, "other").PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+
+	algorithms := store.HostKeyAlgorithms("switch2:2222")
+	expected := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if len(algorithms) != len(expected) {
+		t.Fatalf("HostKeyAlgorithms() = %v, want %v", algorithms, expected)
+	}
+	for i := range expected {
+		if algorithms[i] != expected[i] {
+			t.Errorf("HostKeyAlgorithms() = %v, want %v", algorithms, expected)
+		}
+	}
+	if algorithms := store.HostKeyAlgorithms("switch3"); len(algorithms) != 0 {
+		t.Errorf("expected no algorithms for an unknown host, got %v", algorithms)
+	}
+
+	if n, err := store.Remove("switch1"); err != nil || n != 1 {
+		t.Errorf("Remove() = %d, %v, want 1", n, err)
+	}
+	if n, err := store.Remove("switch1"); err != nil || n != 0 {
+		t.Errorf("Remove() = %d, %v, want 0", n, err)
+	}
+	if _, err := store.Callback("insecure", ""); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestFetchHostKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigner, err := ssh.NewSignerFromKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newFakeSwitch(t, func(s *fakeSwitch) {
+		s.config.AddHostKey(edSigner)
+	})
+	defer s.close()
+	jump := newFakeSwitch(t)
+	defer jump.close()
+	rsaKey := testSigner(t, "host").PublicKey()
+
+	tests := []struct {
+		name     string
+		target   func() Targets
+		stored   ssh.PublicKey
+		expected ssh.PublicKey
+	}{
+		{"default", s.target, nil, rsaKey},
+		{"configured algorithms", func() Targets {
+			target := s.target()
+			target.Algorithms.HostKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+			return target
+		}, nil, edSigner.PublicKey()},
+		{"stored key type", s.target, edSigner.PublicKey(), edSigner.PublicKey()},
+		{"jump host", func() Targets {
+			target := s.target()
+			target.ProxyJump = []JumpHost{{Host: jump.addr(), Credentials: Credentials{Userid: "admin", Password: "secret"}}}
+			return target
+		}, nil, rsaKey},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "hostkeys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		store := NewHostKeyStore(filepath.Join(dir, "known_hosts"))
+		if test.stored != nil {
+			if err := store.Add(s.addr(), test.stored); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m, _ := NewConnectionManager(WithHostKeyStore(store))
+		key, err := m.FetchHostKey(test.target())
+		m.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(test.expected) {
+			t.Errorf("%s: FetchHostKey() = %s %s, want %s %s", test.name, key.Type(), ssh.FingerprintSHA256(key), test.expected.Type(), ssh.FingerprintSHA256(test.expected))
+		}
+	}
+	if n := s.loginCount(); n != 0 {
+		t.Errorf("expected no login, got %d", n)
+	}
+	if n := jump.loginCount(); n != 1 {
+		t.Errorf("expected the jump host to be logged into, got %d logins", n)
+	}
+}
+
+func connectFailures(m *SSHConnectionManager, target string, reason string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.connectFailures[connectFailure{target, reason}]
+}
+
+func TestConnectFailures(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+
+	closed := newFakeSwitch(t)
+	unreachable := closed.target()
+	closed.close()
+
+	wrongPassword := s.target()
+	wrongPassword.Password = "wrong"
+
+	tests := []struct {
+		name   string
+		target Targets
+		opts   []Option
+		reason string
+	}{
+		// the host key is checked first, as the failed login stores it on first use
+		{"host key", s.target(), []Option{WithHostKeyPolicy(HostKeyPolicyStrict)}, connectFailureHostKey},
+		{"dial", unreachable, nil, connectFailureDial},
+		{"auth", wrongPassword, nil, connectFailureAuth},
+	}
+	for _, test := range tests {
+		m := newTestManager(s, test.opts...)
+		if _, err := m.Connect(context.Background(), test.target); err == nil {
+			t.Errorf("%s: expected the connection to fail", test.name)
+		}
+		if n := connectFailures(m, test.target.IpAddress, test.reason); n != 1 {
+			t.Errorf("%s: expected 1 failure with reason %s, got %v", test.name, test.reason, n)
+		}
+		m.Close()
+	}
+}
@@ -0,0 +1,199 @@
+package connector
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc opens the TCP connection to a device
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// jumpClient is an SSH connection to a jump host which is shared by all
+// connections tunneled through it
+type jumpClient struct {
+	key      string
+	client   *ssh.Client
+	users    int
+	lastUsed time.Time
+}
+
+// tunnelConn is a connection tunneled through a jump host. Closing it releases the jump host.
+type tunnelConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *tunnelConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// dialer returns the function opening the connections to a target, either directly,
+// through a SOCKS5 proxy or tunneled through the chain of jump hosts of the target
+func (m *SSHConnectionManager) dialer(target Targets) (dialFunc, error) {
+	forward := &net.Dialer{Timeout: target.ConnectTimeout}
+	dial := dialFunc(forward.Dial)
+	if target.SocksProxy != "" {
+		proxyURL, err := target.SocksProxy.Value()
+		if err != nil {
+			return nil, err
+		}
+		// The parse error is left out, it contains the URL with the password
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, errors.New("invalid socksProxy URL")
+		}
+		d, err := proxy.FromURL(u, forward)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid socksProxy %s", u.Redacted())
+		}
+		dial = d.Dial
+	}
+
+	key := string(target.SocksProxy)
+	for _, hop := range target.ProxyJump {
+		host, err := CanonicalAddress(hop.Host, defaultSSHPort)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid jump host %s", hop.Host)
+		}
+		config, err := m.clientConfig(Targets{
+			IpAddress:          hop.Host,
+			Credentials:        hop.Credentials,
+			HostKeyPolicy:      hop.HostKeyPolicy,
+			HostKeyFingerprint: hop.HostKeyFingerprint,
+			Algorithms:         hop.Algorithms,
+			ConnectTimeout:     target.ConnectTimeout,
+		}, host)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid jump host %s", hop.Host)
+		}
+		key += "|" + hop.Userid + "@" + host
+		dial = m.jumpDialer(key, host, config, hop.Credentials, dial)
+	}
+	return dial, nil
+}
+
+// jumpDialer returns a function which opens connections through the jump host.
+// The SSH connection to the jump host is opened with the dial function of the previous hop
+// on first use and kept open as long as connections are tunneled through it.
+func (m *SSHConnectionManager) jumpDialer(key string, host string, config *ssh.ClientConfig, creds Credentials, previous dialFunc) dialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		jump, err := m.jumpClient(key, host, config, previous)
+		if isAuthError(err) {
+			// Read a rotated password again on the next attempt
+			creds.forgetSecrets()
+		}
+		if err != nil {
+			return nil, err
+		}
+		conn, err := jump.client.Dial(network, addr)
+		if err != nil {
+			m.releaseJump(jump)
+			return nil, errors.Wrapf(err, "could not open tunnel from %s to %s", host, addr)
+		}
+		return &tunnelConn{Conn: conn, release: func() { m.releaseJump(jump) }}, nil
+	}
+}
+
+// jumpClient returns the shared connection to a jump host, connecting if there is none yet.
+// The lock is not held while connecting, as the previous hop of the chain takes it as well.
+func (m *SSHConnectionManager) jumpClient(key string, host string, config *ssh.ClientConfig, previous dialFunc) (*jumpClient, error) {
+	m.jumpMu.Lock()
+	jump, found := m.jumps[key]
+	if found {
+		jump.users++
+		jump.lastUsed = time.Now()
+		m.jumpMu.Unlock()
+		return jump, nil
+	}
+	m.jumpMu.Unlock()
+
+	level.Debug(logging.Base()).Log("msg", "Connecting to jump host", "host", host)
+	conn, err := previous("tcp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not connect to jump host %s", host)
+	}
+	// The jump host is shared by all scrapes, so the handshake doesn't depend on the context of this one
+	c, chans, reqs, err := handshake(context.Background(), conn, host, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not log in to jump host %s", host)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+
+	m.jumpMu.Lock()
+	if existing, found := m.jumps[key]; found {
+		// Another scrape connected to the jump host in the meantime
+		existing.users++
+		existing.lastUsed = time.Now()
+		m.jumpMu.Unlock()
+		client.Close()
+		return existing, nil
+	}
+	jump = &jumpClient{key: key, client: client, users: 1, lastUsed: time.Now()}
+	m.jumps[key] = jump
+	m.jumpMu.Unlock()
+
+	go m.forgetJump(jump)
+	return jump, nil
+}
+
+// forgetJump drops the connection to a jump host once it is closed, so that the next dial reconnects
+func (m *SSHConnectionManager) forgetJump(jump *jumpClient) {
+	err := jump.client.Wait()
+	level.Debug(logging.Base()).Log("msg", "Connection to jump host closed", "host", jump.client.RemoteAddr(), "err", err)
+
+	m.jumpMu.Lock()
+	defer m.jumpMu.Unlock()
+	if m.jumps[jump.key] == jump {
+		delete(m.jumps, jump.key)
+	}
+}
+
+func (m *SSHConnectionManager) releaseJump(jump *jumpClient) {
+	m.jumpMu.Lock()
+	defer m.jumpMu.Unlock()
+
+	jump.users--
+	jump.lastUsed = time.Now()
+}
+
+// closeIdleJumps closes the connections to jump hosts no connection was tunneled through within the idle timeout
+func (m *SSHConnectionManager) closeIdleJumps() {
+	m.jumpMu.Lock()
+	var idle []*jumpClient
+	for key, jump := range m.jumps {
+		if jump.users == 0 && time.Since(jump.lastUsed) > m.idleTimeout {
+			idle = append(idle, jump)
+			delete(m.jumps, key)
+		}
+	}
+	m.jumpMu.Unlock()
+
+	// Closing a connection tunneled through another jump host releases that one, so the lock must not be held
+	for _, jump := range idle {
+		level.Debug(logging.Base()).Log("msg", "Closing idle connection to jump host", "host", jump.client.RemoteAddr())
+		jump.client.Close()
+	}
+}
+
+// closeJumps closes the connections to all jump hosts
+func (m *SSHConnectionManager) closeJumps() {
+	m.jumpMu.Lock()
+	jumps := m.jumps
+	m.jumps = make(map[string]*jumpClient)
+	m.jumpMu.Unlock()
+
+	for _, jump := range jumps {
+		jump.client.Close()
+	}
+}
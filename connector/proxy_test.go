@@ -0,0 +1,214 @@
+package connector
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// socksProxy is a minimal SOCKS5 server without authentication
+type socksProxy struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	connections int
+}
+
+func newSocksProxy(t *testing.T) *socksProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &socksProxy{listener: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(c)
+		}
+	}()
+	return p
+}
+
+func (p *socksProxy) handle(c net.Conn) {
+	// greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		c.Close()
+		return
+	}
+	io.CopyN(ioutil.Discard, c, int64(header[1]))
+	c.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c, request); err != nil || request[1] != 1 {
+		c.Close()
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		io.ReadFull(c, length)
+		name := make([]byte, length[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	default:
+		c.Close()
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(c, port)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		c.Close()
+		return
+	}
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	p.mu.Lock()
+	p.connections++
+	p.mu.Unlock()
+	pipe(c, conn)
+}
+
+func jumpCount(m *SSHConnectionManager) int {
+	m.jumpMu.Lock()
+	defer m.jumpMu.Unlock()
+
+	return len(m.jumps)
+}
+
+func TestConnectThroughJumpHosts(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	first := newFakeSwitch(t)
+	defer first.close()
+	second := newFakeSwitch(t)
+	defer second.close()
+	m := newTestManager(s, WithMaxConnectionsPerHost(2))
+	defer m.Close()
+
+	target := s.target()
+	target.ProxyJump = []JumpHost{
+		{Host: first.addr(), Credentials: Credentials{Userid: "admin", Password: "secret"}},
+		{Host: second.addr(), Credentials: Credentials{Userid: "admin", Password: "secret"}},
+	}
+	c1, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c1)
+	if _, err := c1.RunCommand(context.Background(), "uptime"); err != nil {
+		t.Fatal(err)
+	}
+	// the second connection is tunneled through the jump hosts already connected
+	c2, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c2)
+	if c1 == c2 {
+		t.Fatal("expected two connections")
+	}
+
+	for name, jump := range map[string]*fakeSwitch{"first": first, "second": second} {
+		if n := jump.loginCount(); n != 1 {
+			t.Errorf("expected 1 login on the %s jump host, got %d", name, n)
+		}
+	}
+	first.mu.Lock()
+	if first.forwards != 1 {
+		t.Errorf("expected 1 tunnel through the first jump host, got %d", first.forwards)
+	}
+	first.mu.Unlock()
+	second.mu.Lock()
+	if second.forwards != 2 {
+		t.Errorf("expected 2 tunnels through the second jump host, got %d", second.forwards)
+	}
+	second.mu.Unlock()
+	if n := jumpCount(m); n != 2 {
+		t.Errorf("expected 2 jump host connections, got %d", n)
+	}
+
+	target.ProxyJump = []JumpHost{{Host: first.addr(), Credentials: Credentials{Userid: "admin", Password: "wrong"}}}
+	m2 := newTestManager(s)
+	defer m2.Close()
+	if _, err := m2.Connect(context.Background(), target); err == nil {
+		t.Error("expected the login to the jump host to fail")
+	}
+}
+
+func TestCloseIdleJumpHosts(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	jump := newFakeSwitch(t)
+	defer jump.close()
+	m := newTestManager(s, WithIdleTimeout(50*time.Millisecond))
+	defer m.Close()
+
+	target := s.target()
+	target.ProxyJump = []JumpHost{{Host: jump.addr(), Credentials: Credentials{Userid: "admin", Password: "secret"}}}
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := jumpCount(m); n != 1 {
+		t.Fatalf("expected the jump host to stay connected while it is used, got %d connections", n)
+	}
+
+	// closing the idle connection to the switch releases the jump host, which is closed next
+	m.Release(c)
+	waitFor(t, 2*time.Second, func() bool { return jumpCount(m) == 0 })
+}
+
+func TestConnectThroughSocksProxy(t *testing.T) {
+	s := newFakeSwitch(t)
+	defer s.close()
+	p := newSocksProxy(t)
+	defer p.listener.Close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	target := s.target()
+	target.SocksProxy = Secret("socks5://" + p.listener.Addr().String())
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Release(c)
+	if _, err := c.RunCommand(context.Background(), "uptime"); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.connections != 1 {
+		t.Errorf("expected 1 connection through the proxy, got %d", p.connections)
+	}
+}
+
+func TestDialerInvalidProxy(t *testing.T) {
+	m, _ := NewConnectionManager()
+	defer m.Close()
+
+	for _, proxy := range []string{"http://proxy:3128", "socks5://%zz"} {
+		if _, err := m.dialer(Targets{IpAddress: "switch", SocksProxy: Secret(proxy)}); err == nil {
+			t.Errorf("expected %s to be rejected", proxy)
+		}
+	}
+}
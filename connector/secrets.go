@@ -0,0 +1,151 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// passwordCommandTimeout bounds the runtime of a passwordCommand
+const passwordCommandTimeout = 10 * time.Second
+
+const redacted = "<secret>"
+
+// envRegexp matches the ${NAME} references to environment variables in secrets.
+// The short form $NAME is not expanded, as passwords may contain a dollar sign.
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Secret is a configuration value which is never printed, logged or marshaled.
+// It may reference environment variables as ${NAME}.
+type Secret string
+
+// String implements fmt.Stringer, so that secrets are redacted when they are formatted
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString redacts the secret when it is formatted with %#v
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return redacted, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Value returns the secret with the environment variables expanded
+func (s Secret) Value() (string, error) {
+	var missing []string
+	value := envRegexp.ReplaceAllStringFunc(string(s), func(ref string) string {
+		name := envRegexp.FindStringSubmatch(ref)[1]
+		v, found := os.LookupEnv(name)
+		if !found {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", errors.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return value, nil
+}
+
+var (
+	// secretCache holds the passwords read from files and commands until authentication with them fails
+	secretCache   = make(map[string]string)
+	secretCacheMu sync.Mutex
+)
+
+// hasPassword tells whether a password is configured in one of the supported ways
+func (c Credentials) hasPassword() bool {
+	return c.Password != "" || c.PasswordFile != "" || len(c.PasswordCommand) > 0
+}
+
+// password returns the password of the credentials, from the configuration, the password file or
+// the output of the password command. Passwords read from files and commands are cached.
+func (c Credentials) password() (string, error) {
+	if c.Password != "" {
+		return c.Password.Value()
+	}
+	key := c.secretKey()
+	if key == "" {
+		return "", nil
+	}
+
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+	if password, found := secretCache[key]; found {
+		return password, nil
+	}
+
+	var password string
+	if c.PasswordFile != "" {
+		content, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not read passwordFile of user %s", c.Userid)
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+		defer cancel()
+		var stdout bytes.Buffer
+		cmd := exec.CommandContext(ctx, c.PasswordCommand[0], c.PasswordCommand[1:]...)
+		cmd.Stdout = &stdout
+		// The output is left out of the error, it may contain the secret
+		if err := cmd.Run(); err != nil {
+			return "", errors.Wrapf(err, "passwordCommand %s of user %s failed", c.PasswordCommand[0], c.Userid)
+		}
+		password = strings.TrimRight(stdout.String(), "\r\n")
+	}
+	secretCache[key] = password
+	return password, nil
+}
+
+// forgetSecrets drops the cached password, so that it is read again on the next login.
+// It returns false if the password is not read from a file or command.
+func (c Credentials) forgetSecrets() bool {
+	key := c.secretKey()
+	if key == "" {
+		return false
+	}
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+	delete(secretCache, key)
+	return true
+}
+
+func (c Credentials) secretKey() string {
+	if c.PasswordFile != "" {
+		return "file:" + c.PasswordFile
+	}
+	if len(c.PasswordCommand) > 0 {
+		return "command:" + strings.Join(c.PasswordCommand, "\x00")
+	}
+	return ""
+}
+
+// isAuthError tells whether a connection failed because the device rejected the credentials
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "unable to authenticate")
+}
@@ -0,0 +1,188 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecretRedaction(t *testing.T) {
+	creds := Credentials{Userid: "admin", Password: "s3cr3t", PrivateKeyPassphrase: "passphrase"}
+
+	formatted := []string{
+		fmt.Sprintf("%v", creds),
+		fmt.Sprintf("%+v", creds),
+		fmt.Sprintf("%#v", creds),
+		fmt.Sprintf("%s", creds.Password),
+	}
+	out, err := yaml.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted = append(formatted, string(out))
+	out, err = json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decode the JSON again, as it escapes the angle brackets of the placeholder
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	formatted = append(formatted, fmt.Sprint(decoded))
+
+	for _, text := range formatted {
+		if strings.Contains(text, "s3cr3t") || strings.Contains(text, "passphrase\"") {
+			t.Errorf("secret leaked in %q", text)
+		}
+		if !strings.Contains(text, redacted) {
+			t.Errorf("expected the secret to be redacted in %q", text)
+		}
+	}
+
+	if s := Secret("").String(); s != "" {
+		t.Errorf("expected an empty secret to stay empty, got %q", s)
+	}
+}
+
+func TestSecretValue(t *testing.T) {
+	os.Setenv("FOS_TEST_PASSWORD", "s3cr3t")
+	defer os.Unsetenv("FOS_TEST_PASSWORD")
+	os.Unsetenv("FOS_TEST_MISSING")
+
+	tests := []struct {
+		secret   Secret
+		expected string
+		fails    bool
+	}{
+		{"plain", "plain", false},
+		{"${FOS_TEST_PASSWORD}", "s3cr3t", false},
+		{"prefix-${FOS_TEST_PASSWORD}-suffix", "prefix-s3cr3t-suffix", false},
+		{"pa$$word", "pa$$word", false},
+		{"$FOS_TEST_PASSWORD", "$FOS_TEST_PASSWORD", false},
+		{"${FOS_TEST_MISSING}", "", true},
+	}
+	for _, test := range tests {
+		value, err := test.secret.Value()
+		if test.fails {
+			if err == nil {
+				t.Errorf("Value() of %q: expected an error", string(test.secret))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Value() of %q: %v", string(test.secret), err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("Value() of %q = %q, want %q", string(test.secret), value, test.expected)
+		}
+	}
+}
+
+func TestPasswordFromFileAndCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		creds    Credentials
+		expected string
+		fails    bool
+	}{
+		{Credentials{Userid: "admin", PasswordFile: file}, "from-file", false},
+		{Credentials{Userid: "admin", PasswordCommand: []string{"echo", "from-command"}}, "from-command", false},
+		{Credentials{Userid: "admin", Password: "inline", PasswordFile: file}, "inline", false},
+		{Credentials{Userid: "admin", PasswordFile: filepath.Join(dir, "missing")}, "", true},
+		{Credentials{Userid: "admin", PasswordCommand: []string{"false"}}, "", true},
+	}
+	for _, test := range tests {
+		password, err := test.creds.password()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%+v: expected an error", test.creds)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test.creds, err)
+			continue
+		}
+		if password != test.expected {
+			t.Errorf("%+v: password() = %q, want %q", test.creds, password, test.expected)
+		}
+		test.creds.forgetSecrets()
+	}
+
+	// the password is cached until it is forgotten
+	creds := Credentials{Userid: "admin", PasswordFile: file}
+	creds.password()
+	defer creds.forgetSecrets()
+	ioutil.WriteFile(file, []byte("rotated\n"), 0600)
+	if password, _ := creds.password(); password != "from-file" {
+		t.Errorf("expected the cached password, got %q", password)
+	}
+	if !creds.forgetSecrets() {
+		t.Error("expected the password read from a file to be forgotten")
+	}
+	if password, _ := creds.password(); password != "rotated" {
+		t.Errorf("expected the rotated password, got %q", password)
+	}
+	if (Credentials{Password: "inline"}).forgetSecrets() {
+		t.Error("expected an inline password not to be cached")
+	}
+}
+
+func TestConnectWithRotatedPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+
+	s := newFakeSwitch(t)
+	defer s.close()
+	m := newTestManager(s)
+	defer m.Close()
+
+	// the cached password was rotated on the switch and in the file
+	target := s.target()
+	target.Password = ""
+	target.PasswordFile = file
+	ioutil.WriteFile(file, []byte("old\n"), 0600)
+	target.password()
+	defer target.forgetSecrets()
+	ioutil.WriteFile(file, []byte("secret\n"), 0600)
+
+	c, err := m.Connect(context.Background(), target)
+	if err != nil {
+		t.Fatalf("expected the password to be read again: %v", err)
+	}
+	m.Release(c)
+}
+
+func TestValidateSecrets(t *testing.T) {
+	os.Unsetenv("FOS_TEST_MISSING")
+	cfg := &Config{Targets: []Targets{{
+		IpAddress:   "10.0.0.1",
+		Credentials: Credentials{Userid: "admin", Password: "${FOS_TEST_MISSING}"},
+	}}}
+	setDefaultValues(cfg)
+	if problems := cfg.validate(); len(problems) != 1 || !strings.Contains(problems[0], "FOS_TEST_MISSING") {
+		t.Errorf("expected the missing environment variable to be reported, got %v", problems)
+	}
+}
@@ -0,0 +1,295 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	testKeys   = make(map[string]*rsa.PrivateKey)
+	testKeysMu sync.Mutex
+)
+
+// testKey returns a RSA key generated once per name, as generating keys is slow
+func testKey(t *testing.T, name string) *rsa.PrivateKey {
+	testKeysMu.Lock()
+	defer testKeysMu.Unlock()
+
+	if key, found := testKeys[name]; found {
+		return key
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeys[name] = key
+	return key
+}
+
+func testSigner(t *testing.T, name string) ssh.Signer {
+	signer, err := ssh.NewSignerFromKey(testKey(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// fakeSwitch is a minimal SSH server answering exec requests with canned
+// command output, used to test the connection handling without a device
+type fakeSwitch struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	outputs  map[string]string
+	dir      string
+	hostKeys *HostKeyStore
+
+	// authorizedKey is accepted for public key authentication
+	authorizedKey ssh.PublicKey
+	// hang is a command which is never answered
+	hang string
+	// keyboardInteractiveOnly disables the password method like some FOS versions do
+	keyboardInteractiveOnly bool
+
+	mu       sync.Mutex
+	logins   int
+	shells   int
+	forwards int
+	conns    []net.Conn
+}
+
+func newFakeSwitch(t *testing.T, opts ...func(*fakeSwitch)) *fakeSwitch {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "fakeswitch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSwitch{
+		listener: l,
+		dir:      dir,
+		hostKeys: NewHostKeyStore(filepath.Join(dir, "known_hosts")),
+		outputs: map[string]string{
+			"uptime": " 20:46:50 up 216 days, 27 min, 0 users, load average: 0.59, 0.30, 0.19\n",
+		},
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if c.User() == "admin" && string(p) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.authorizedKey != nil && bytes.Equal(key.Marshal(), s.authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("public key rejected for %s", c.User())
+		},
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+			if err == nil && len(answers) == 1 && answers[0] == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("keyboard-interactive rejected for %s", c.User())
+		},
+	}
+	s.config.AddHostKey(testSigner(t, "host"))
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.keyboardInteractiveOnly {
+		s.config.PasswordCallback = nil
+	}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeSwitch) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSwitch) target() Targets {
+	return Targets{IpAddress: s.addr(), Credentials: Credentials{Userid: "admin", Password: "secret"}}
+}
+
+func (s *fakeSwitch) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logins
+}
+
+// dropConnections closes all established connections on the server side
+func (s *fakeSwitch) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeSwitch) close() {
+	s.listener.Close()
+	s.dropConnections()
+	os.RemoveAll(s.dir)
+}
+
+// newTestManager creates a connection manager which stores the host keys next to the fake switch
+func newTestManager(s *fakeSwitch, opts ...Option) *SSHConnectionManager {
+	m, _ := NewConnectionManager(append([]Option{WithHostKeyStore(s.hostKeys)}, opts...)...)
+	return m
+}
+
+func (s *fakeSwitch) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeSwitch) handle(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	s.mu.Lock()
+	s.logins++
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			ch, creqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go s.session(ch, creqs)
+		case "direct-tcpip":
+			s.forward(nc)
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// forward tunnels a connection like a jump host does
+func (s *fakeSwitch) forward(nc ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	ssh.Unmarshal(nc.ExtraData(), &payload)
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.forwards++
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	go pipe(ch, conn)
+}
+
+// pipe copies between two connections until one of them is closed
+func pipe(a io.ReadWriteCloser, b io.ReadWriteCloser) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+func (s *fakeSwitch) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			if s.hang != "" && payload.Command == s.hang {
+				continue
+			}
+			io.WriteString(ch, s.outputs[payload.Command])
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			ch.Close()
+		case "pty-req":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.shells++
+			s.mu.Unlock()
+			go s.shell(ch)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// shell emulates the FOS CLI: every line read is echoed and answered with
+// the output of the command followed by the prompt
+func (s *fakeSwitch) shell(ch ssh.Channel) {
+	const prompt = "SAN1:FID128:admin> "
+	io.WriteString(ch, "\r\n"+prompt)
+	r := bufio.NewReader(ch)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		if s.hang != "" && cmd == s.hang {
+			io.WriteString(ch, cmd+"\r\n")
+			continue
+		}
+		out := strings.Replace(s.outputs[cmd], "\n", "\r\n", -1)
+		io.WriteString(ch, cmd+"\r\n"+out+prompt)
+	}
+}
+
+// waitFor polls cond until it is true or the timeout is reached
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,223 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// The terminal is made wide enough that FOS doesn't wrap the lines of the command outputs
+	shellWidth  = 1024
+	shellHeight = 1000
+	// shellTimeout is the time to wait for the prompt after a command was sent
+	shellTimeout = 60 * time.Second
+)
+
+var (
+	// promptRegexp matches FOS prompts such as "switch:admin> " or, with virtual fabrics, "switch:FID128:admin> "
+	promptRegexp = regexp.MustCompile(`(?:^|\n)[A-Za-z0-9_.\-]+(?::FID\d+)?:[A-Za-z0-9_.\-]+> ?$`)
+	// pagerRegexp matches the pager prompts FOS prints when the output doesn't fit on the terminal
+	pagerRegexp = regexp.MustCompile(`(--More--|Type <CR> to continue, Q<CR> to stop: ?)\s*$`)
+	// escapeRegexp matches ANSI escape sequences, e.g. those erasing the pager prompt
+	escapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+)
+
+// shellSession is an interactive shell on a PTY which runs the commands of a connection in sequence.
+// It is used for accounts which are not allowed to open exec channels and
+// avoids opening a new session for each command.
+type shellSession struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	buf     bytes.Buffer
+	err     error
+	mu      sync.Mutex
+	notify  chan struct{}
+}
+
+// openShell requests a PTY and starts the shell, then waits for the first prompt
+func openShell(ctx context.Context, client *ssh.Client) (*shellSession, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open session")
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 38400,
+	}
+	if err := session.RequestPty("vt100", shellHeight, shellWidth, modes); err != nil {
+		session.Close()
+		return nil, errors.Wrap(err, "could not request pty")
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, errors.Wrap(err, "could not open stdin")
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, errors.Wrap(err, "could not open stdout")
+	}
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, errors.Wrap(err, "could not start shell")
+	}
+
+	s := &shellSession{
+		session: session,
+		stdin:   stdin,
+		notify:  make(chan struct{}, 1),
+	}
+	go s.read(stdout)
+
+	if _, err := s.readUntilPrompt(ctx, shellTimeout); err != nil {
+		s.close()
+		return nil, errors.Wrap(err, "could not detect prompt")
+	}
+	return s, nil
+}
+
+// read copies the shell output into the buffer until the session ends
+func (s *shellSession) read(stdout io.Reader) {
+	b := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(b)
+		s.mu.Lock()
+		s.buf.Write(b[:n])
+		if err != nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// run sends a command and returns its output without the echoed command and the prompt
+func (s *shellSession) run(ctx context.Context, cmd string) (string, error) {
+	if _, err := io.WriteString(s.stdin, cmd+"\n"); err != nil {
+		return "", errors.Wrap(err, "could not send command")
+	}
+	out, err := s.readUntilPrompt(ctx, shellTimeout)
+	if err != nil {
+		return "", err
+	}
+	return trimEcho(out, cmd), nil
+}
+
+// readUntilPrompt reads until the prompt is printed, answering pager prompts on the way.
+// It returns the normalized output without the prompt. It gives up after the timeout or once the context is done.
+func (s *shellSession) readUntilPrompt(ctx context.Context, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	var out strings.Builder
+	var raw string
+	for {
+		s.mu.Lock()
+		raw += s.buf.String()
+		s.buf.Reset()
+		err := s.err
+		s.mu.Unlock()
+
+		// Only the end of the output has to be looked at to find the prompts
+		tail := raw
+		if len(tail) > 512 {
+			tail = tail[len(tail)-512:]
+		}
+		tail = normalizeShellOutput(tail)
+		if promptRegexp.MatchString(tail) {
+			text := normalizeShellOutput(raw)
+			loc := promptRegexp.FindStringIndex(text)
+			out.WriteString(text[:loc[0]])
+			return out.String(), nil
+		}
+		if loc := pagerRegexp.FindStringSubmatchIndex(tail); loc != nil {
+			text := normalizeShellOutput(raw)
+			out.WriteString(pagerRegexp.ReplaceAllString(text, ""))
+			raw = ""
+			answer := " "
+			if strings.HasPrefix(tail[loc[2]:loc[3]], "Type") {
+				answer = "\n"
+			}
+			if _, err := io.WriteString(s.stdin, answer); err != nil {
+				return "", errors.Wrap(err, "could not answer pager prompt")
+			}
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "shell closed before the prompt was printed")
+		}
+
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return "", errors.Errorf("no prompt after %s", timeout)
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "waiting for the prompt")
+		}
+	}
+}
+
+func (s *shellSession) close() {
+	s.stdin.Close()
+	s.session.Close()
+}
+
+// normalizeShellOutput removes the artifacts of the terminal: carriage returns,
+// escape sequences and the blanks which overwrite an erased pager prompt
+func normalizeShellOutput(text string) string {
+	text = escapeRegexp.ReplaceAllString(text, "")
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		// A carriage return inside a line moves the cursor back, only the text written last is visible
+		if idx := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimEcho removes the command echoed by the terminal. Long commands may be
+// echoed over several lines when they are wrapped at the terminal width.
+func trimEcho(out string, cmd string) string {
+	if out == "" {
+		return out
+	}
+	idx := strings.Index(out, "\n")
+	if idx < 0 {
+		idx = len(out)
+	}
+	echo := strings.Replace(out[:idx], " ", "", -1)
+	want := strings.Replace(cmd, " ", "", -1)
+	for len(echo) < len(want) && strings.HasPrefix(want, echo) && idx < len(out) {
+		next := strings.Index(out[idx+1:], "\n")
+		if next < 0 {
+			next = len(out) - idx - 1
+		}
+		echo += strings.Replace(out[idx+1:idx+1+next], " ", "", -1)
+		idx += 1 + next
+	}
+	if echo != want {
+		// The terminal didn't echo the command
+		return out
+	}
+	if idx >= len(out) {
+		return ""
+	}
+	return out[idx+1:]
+}
@@ -0,0 +1,200 @@
+package connector
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTerminal plays a recorded FOS shell session. Each write of the client, a command or
+// the answer to a pager prompt, is answered with the next recorded chunk of output.
+type fakeTerminal struct {
+	s      *shellSession
+	chunks []string
+	input  []string
+}
+
+func newFakeTerminal(chunks ...string) *fakeTerminal {
+	f := &fakeTerminal{chunks: chunks}
+	f.s = &shellSession{stdin: f, notify: make(chan struct{}, 1)}
+	return f
+}
+
+func (f *fakeTerminal) Write(b []byte) (int, error) {
+	f.input = append(f.input, string(b))
+	if len(f.chunks) == 0 {
+		return len(b), nil
+	}
+	f.s.mu.Lock()
+	f.s.buf.WriteString(f.chunks[0])
+	f.s.mu.Unlock()
+	f.chunks = f.chunks[1:]
+	select {
+	case f.s.notify <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (f *fakeTerminal) Close() error {
+	return nil
+}
+
+func TestShellRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		chunks   []string
+		expected string
+		input    []string
+	}{
+		{
+			name: "virtual fabric prompt",
+			cmd:  "switchshow",
+			chunks: []string{
+				"switchshow\r\nswitchName:\tSAN1\r\nswitchType:\t162.0\r\nswitchState:\tOnline\r\nSAN1:FID128:admin> ",
+			},
+			expected: "switchName:\tSAN1\nswitchType:\t162.0\nswitchState:\tOnline",
+			input:    []string{"switchshow\n"},
+		},
+		{
+			name: "prompt without trailing blank",
+			cmd:  "firmwareshow",
+			chunks: []string{
+				"firmwareshow\r\nAppl     Primary/Secondary Versions\r\n------------------------------------------\r\nFOS      v9.1.1a\r\n         v9.1.1a\r\nswitch:admin>",
+			},
+			expected: "Appl     Primary/Secondary Versions\n------------------------------------------\nFOS      v9.1.1a\n         v9.1.1a",
+			input:    []string{"firmwareshow\n"},
+		},
+		{
+			name: "more pager erased with escape sequences",
+			cmd:  "sfpshow -all",
+			chunks: []string{
+				"sfpshow -all\r\n=============\r\nPort  0:\r\n=============\r\nIdentifier:  3    SFP\r\n--More--",
+				"\r\x1b[K=============\r\nPort  1:\r\n=============\r\nIdentifier:  3    SFP\r\n--More--",
+				"\r        \rTemperature: 38      Centigrade\r\nSAN1:FID128:admin> ",
+			},
+			expected: "=============\nPort  0:\n=============\nIdentifier:  3    SFP\n" +
+				"=============\nPort  1:\n=============\nIdentifier:  3    SFP\n" +
+				"Temperature: 38      Centigrade",
+			input: []string{"sfpshow -all\n", " ", " "},
+		},
+		{
+			name: "continue pager",
+			cmd:  "errdump",
+			chunks: []string{
+				"errdump\r\nFabric OS: v9.1.1a\r\n\r\n2023/03/01-10:00:01, [SEC-1203], 1, FID 128, INFO, SAN1, Login information\r\nType <CR> to continue, Q<CR> to stop: ",
+				"\r\n2023/03/01-10:05:12, [SNMP-1005], 2, FID 128, INFO, SAN1, SNMP configuration attribute changed\r\nswitch:FID128:admin> ",
+			},
+			expected: "Fabric OS: v9.1.1a\n\n2023/03/01-10:00:01, [SEC-1203], 1, FID 128, INFO, SAN1, Login information\n" +
+				"\n2023/03/01-10:05:12, [SNMP-1005], 2, FID 128, INFO, SAN1, SNMP configuration attribute changed",
+			input: []string{"errdump\n", "\n"},
+		},
+		{
+			name: "wrapped command echo",
+			cmd:  "portstatsshow 0 -verbose -long",
+			chunks: []string{
+				"portstatsshow 0 -verbose -l\r\nong\r\nstat_wtx            \t113218826   4-byte words transmitted\r\nstat_wrx            \t4275290464  4-byte words received\r\nSAN1:admin> ",
+			},
+			expected: "stat_wtx            \t113218826   4-byte words transmitted\nstat_wrx            \t4275290464  4-byte words received",
+			input:    []string{"portstatsshow 0 -verbose -long\n"},
+		},
+		{
+			name:     "no output",
+			cmd:      "bannershow",
+			chunks:   []string{"bannershow\r\nSAN1:admin> "},
+			expected: "",
+			input:    []string{"bannershow\n"},
+		},
+	}
+	for _, test := range tests {
+		f := newFakeTerminal(test.chunks...)
+		out, err := f.s.run(context.Background(), test.cmd)
+		if err != nil {
+			t.Errorf("%s: run() failed: %s", test.name, err)
+			continue
+		}
+		if out != test.expected {
+			t.Errorf("%s: run() = %q, want %q", test.name, out, test.expected)
+		}
+		if strings.Join(f.input, "|") != strings.Join(test.input, "|") {
+			t.Errorf("%s: sent %q, want %q", test.name, f.input, test.input)
+		}
+	}
+}
+
+func TestShellRunClosed(t *testing.T) {
+	f := newFakeTerminal("switchshow\r\nswitchName:\tSAN1\r\n")
+	f.s.err = io.EOF
+	if _, err := f.s.run(context.Background(), "switchshow"); err == nil {
+		t.Error("run() succeeded on a closed shell")
+	}
+
+	f = newFakeTerminal("switchshow\r\nswitchName:\tSAN1\r\n")
+	if _, err := f.s.readUntilPrompt(context.Background(), 10*time.Millisecond); err == nil {
+		t.Error("readUntilPrompt() succeeded without prompt")
+	}
+}
+
+func TestPromptRegexp(t *testing.T) {
+	tests := map[string]bool{
+		"SAN1:admin> ":                    true,
+		"SAN1:admin>":                     true,
+		"switch:FID128:admin> ":           true,
+		"Brocade_G620.lab:FID1:user-ro> ": true,
+		"Online\r\n":                      false,
+		"output\nSAN1:FID128:admin> ":     true,
+		"output SAN1:admin> ":             false,
+		"Enter your choice [no]: ":        false,
+		"SAN1:admin> switchshow":          false,
+		"switch:FIDx:admin> ":             false,
+		"  1: fffc01 10:00:88:94:71:61:5d:73 172.16.64.17    0.0.0.0        >\"SAN1\"": false,
+	}
+	for text, expected := range tests {
+		if got := promptRegexp.MatchString(text); got != expected {
+			t.Errorf("promptRegexp.MatchString(%q) = %v, want %v", text, got, expected)
+		}
+	}
+}
+
+func TestNormalizeShellOutput(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"switchName:\tSAN1\r\nswitchState:\tOnline\r\n", "switchName:\tSAN1\nswitchState:\tOnline\n"},
+		{"\x1b[?1hPort  0:\r\n", "Port  0:\n"},
+		{"\r\x1b[KIdentifier:  3    SFP\r\n", "Identifier:  3    SFP\n"},
+		{"\r        \rTemperature: 38      Centigrade\r\n", "Temperature: 38      Centigrade\n"},
+		{"trailing carriage return\r\r\n", "trailing carriage return\n"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeShellOutput(test.text); got != test.expected {
+			t.Errorf("normalizeShellOutput(%q) = %q, want %q", test.text, got, test.expected)
+		}
+	}
+}
+
+func TestTrimEcho(t *testing.T) {
+	tests := []struct {
+		out      string
+		cmd      string
+		expected string
+	}{
+		{"switchshow\nswitchName:\tSAN1", "switchshow", "switchName:\tSAN1"},
+		{"switchshow", "switchshow", ""},
+		{"switchName:\tSAN1", "switchshow", "switchName:\tSAN1"},
+		{"portstatsshow 0 -verbose -l\nong\nstat_wtx 1", "portstatsshow 0 -verbose -long", "stat_wtx 1"},
+		{"portstatsshow 0\n -verbose\n -long\nstat_wtx 1", "portstatsshow 0 -verbose -long", "stat_wtx 1"},
+		{"portstatsshow 0 -verbose -l\nstat_wtx 1", "portstatsshow 0 -verbose -long", "portstatsshow 0 -verbose -l\nstat_wtx 1"},
+		{"", "switchshow", ""},
+	}
+	for _, test := range tests {
+		if got := trimEcho(test.out, test.cmd); got != test.expected {
+			t.Errorf("trimEcho(%q, %q) = %q, want %q", test.out, test.cmd, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gosnmp/gosnmp"
+	"github.com/pkg/errors"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+var (
+	snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+		"":       gosnmp.NoAuth,
+		"MD5":    gosnmp.MD5,
+		"SHA":    gosnmp.SHA,
+		"SHA224": gosnmp.SHA224,
+		"SHA256": gosnmp.SHA256,
+		"SHA384": gosnmp.SHA384,
+		"SHA512": gosnmp.SHA512,
+	}
+	snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+		"":        gosnmp.NoPriv,
+		"DES":     gosnmp.DES,
+		"AES":     gosnmp.AES,
+		"AES192":  gosnmp.AES192,
+		"AES256":  gosnmp.AES256,
+		"AES192C": gosnmp.AES192C,
+		"AES256C": gosnmp.AES256C,
+	}
+	snmpSecurityLevels = map[string]gosnmp.SnmpV3MsgFlags{
+		"noAuthNoPriv": gosnmp.NoAuthNoPriv,
+		"authNoPriv":   gosnmp.AuthNoPriv,
+		"authPriv":     gosnmp.AuthPriv,
+	}
+)
+
+// SNMPConnection encapsulates an SNMP session with the device
+type SNMPConnection struct {
+	host   string
+	client *gosnmp.GoSNMP
+}
+
+// NewSNMPConnection opens an SNMP session to the target. The requests are aborted once the context is done.
+func NewSNMPConnection(ctx context.Context, target Targets) (*SNMPConnection, error) {
+	cfg := target.SNMP
+	// A port in the address is the SSH port, the SNMP port is configured separately
+	host, _, err := SplitAddress(target.IpAddress, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	client := &gosnmp.GoSNMP{
+		Context:        ctx,
+		Target:         host,
+		Port:           cfg.Port,
+		Timeout:        timeoutInSeconds * time.Second,
+		Retries:        1,
+		MaxOids:        gosnmp.MaxOids,
+		MaxRepetitions: 25,
+	}
+
+	community, err := cfg.Community.Value()
+	if err != nil {
+		return nil, err
+	}
+	authPassword, err := cfg.AuthPassword.Value()
+	if err != nil {
+		return nil, err
+	}
+	privPassword, err := cfg.PrivPassword.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Version {
+	case "2c":
+		client.Version = gosnmp.Version2c
+		client.Community = community
+	case "3":
+		level, ok := snmpSecurityLevels[cfg.SecurityLevel]
+		if !ok {
+			level = gosnmp.AuthPriv
+			if cfg.PrivProtocol == "" {
+				level = gosnmp.AuthNoPriv
+			}
+			if cfg.AuthProtocol == "" {
+				level = gosnmp.NoAuthNoPriv
+			}
+		}
+		authProtocol, ok := snmpAuthProtocols[strings.ToUpper(cfg.AuthProtocol)]
+		if !ok {
+			return nil, errors.Errorf("unsupported SNMP auth protocol %q", cfg.AuthProtocol)
+		}
+		privProtocol, ok := snmpPrivProtocols[strings.ToUpper(cfg.PrivProtocol)]
+		if !ok {
+			return nil, errors.Errorf("unsupported SNMP privacy protocol %q", cfg.PrivProtocol)
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = level
+		client.ContextName = cfg.ContextName
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 cfg.Username,
+			AuthenticationProtocol:   authProtocol,
+			AuthenticationPassphrase: authPassword,
+			PrivacyProtocol:          privProtocol,
+			PrivacyPassphrase:        privPassword,
+		}
+	default:
+		return nil, errors.Errorf("unsupported SNMP version %q", cfg.Version)
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, errors.Wrapf(err, "could not open SNMP session to %s", target.IpAddress)
+	}
+
+	return &SNMPConnection{host: target.IpAddress, client: client}, nil
+}
+
+// Get fetches single scalar objects from the device
+func (c *SNMPConnection) Get(oids ...string) ([]gosnmp.SnmpPDU, error) {
+	level.Debug(logging.With("target", c.host)).Log("msg", "Getting OIDs", "oids", fmt.Sprint(oids))
+	packet, err := c.client.Get(oids)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Getting %v on %s", oids, c.host)
+	}
+	return packet.Variables, nil
+}
+
+// Walk retrieves all objects below the given OID
+func (c *SNMPConnection) Walk(oid string) ([]gosnmp.SnmpPDU, error) {
+	level.Debug(logging.With("target", c.host)).Log("msg", "Walking OID", "oid", oid)
+	pdus, err := c.client.BulkWalkAll(oid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Walking %s on %s", oid, c.host)
+	}
+	return pdus, nil
+}
+
+// Close closes the SNMP session
+func (c *SNMPConnection) Close() error {
+	if c.client.Conn == nil {
+		return nil
+	}
+	return c.client.Conn.Close()
+}
+
+// Host returns the hostname connected to
+func (c *SNMPConnection) Host() string {
+	return c.host
+}
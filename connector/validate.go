@@ -0,0 +1,290 @@
+package connector
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hostnameRegexp matches DNS names as defined in RFC 1123
+var hostnameRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*\.?$`)
+
+// labelNameRegexp matches valid Prometheus label names
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// CollectorNames are the collectors targets can select, they are registered by the collector package
+var CollectorNames []string
+
+// SNMPCollectorNames are the collectors which also collect over SNMP, they are registered by the collector package
+var SNMPCollectorNames []string
+
+// ReservedLabelNames are the labels set by the collectors, which static labels must not override
+var ReservedLabelNames []string
+
+// ConfigError lists all problems found in a configuration file
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// problems collects the validation errors of a configuration
+type problems []string
+
+func (p *problems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// validate checks the whole configuration and returns every problem found
+func (c *Config) validate() []string {
+	var p problems
+	if _, err := resolveAlgorithms(c.Algorithms); err != nil {
+		p.add("algorithms: %s", err)
+	}
+
+	for name, profile := range c.Auths {
+		validateSecrets("auths."+name, &p, profile.Password, profile.PrivateKeyPassphrase)
+	}
+	for _, name := range c.Defaults.Collectors {
+		if !contains(CollectorNames, name) {
+			p.add("defaults: unknown collector %q", name)
+		}
+	}
+	validateLabels("defaults", c.Defaults.Labels, &p)
+	var modules []string
+	for name := range c.Modules {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+	for _, name := range modules {
+		module := c.Modules[name]
+		if len(module.Collectors) == 0 && module.FullMetrics == nil {
+			p.add("modules.%s: collectors or fullMetrics is required", name)
+		}
+		for _, collector := range module.Collectors {
+			if !contains(CollectorNames, collector) {
+				p.add("modules.%s: unknown collector %q", name, collector)
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	for i, t := range c.Targets {
+		name := fmt.Sprintf("targets[%d]", i)
+		if t.IpAddress != "" {
+			name = fmt.Sprintf("target %s", t.IpAddress)
+			// The same switch may be written in different ways, e.g. [::1]:22 and ::1
+			address, err := CanonicalAddress(t.IpAddress, t.Port)
+			if err != nil {
+				address = t.IpAddress
+			}
+			if first, found := seen[address]; found {
+				p.add("%s: duplicate of targets[%d]", name, first)
+			} else {
+				seen[address] = i
+			}
+		}
+		for _, alias := range t.Aliases {
+			key, err := CanonicalAddress(alias, t.Port)
+			if err != nil {
+				key = alias
+			}
+			if first, found := seen[key]; found {
+				p.add("%s: alias %q is already used by targets[%d]", name, alias, first)
+			} else {
+				seen[key] = i
+			}
+		}
+		if t.Auth != "" {
+			if _, found := c.Auths[t.Auth]; !found {
+				p.add("%s: unknown auth %q", name, t.Auth)
+			}
+		}
+		for j, hop := range t.ProxyJump {
+			if _, found := c.Auths[hop.Auth]; hop.Auth != "" && !found {
+				p.add("%s: proxyJump[%d]: unknown auth %q", name, j, hop.Auth)
+			}
+		}
+		t.validate(name, c.Defaults, c.Algorithms, &p)
+	}
+	return p
+}
+
+// validate checks a target. Algorithms inherited from the global settings were already checked.
+func (t *Targets) validate(name string, defaults Defaults, global Algorithms, p *problems) {
+	if t.IpAddress == "" {
+		p.add("%s: ipAddress is required", name)
+	} else if err := validateAddress(t.IpAddress); err != nil {
+		p.add("%s: invalid ipAddress: %s", name, err)
+	}
+
+	switch t.Transport {
+	case TransportSSH:
+		t.Credentials.validate(name, p)
+	case TransportSNMP:
+		t.SNMP.validate(name, p)
+	default:
+		p.add("%s: unknown transport %q, must be %s or %s", name, t.Transport, TransportSSH, TransportSNMP)
+	}
+	if t.ConnectTimeout < 0 || t.CommandTimeout < 0 {
+		p.add("%s: timeouts must not be negative", name)
+	}
+	if t.PollInterval != nil && *t.PollInterval < 0 {
+		p.add("%s: pollInterval must not be negative", name)
+	}
+	// The collectors inherited from the defaults were already checked
+	if !reflect.DeepEqual(t.Collectors, defaults.Collectors) {
+		for _, collector := range t.Collectors {
+			if !contains(CollectorNames, collector) {
+				p.add("%s: unknown collector %q", name, collector)
+			}
+		}
+	}
+	if t.Transport == TransportSNMP {
+		for _, collector := range t.Collectors {
+			if contains(CollectorNames, collector) && !contains(SNMPCollectorNames, collector) {
+				p.add("%s: collector %q doesn't support transport %s", name, collector, TransportSNMP)
+			}
+		}
+	}
+	// The labels inherited from the defaults were already checked
+	own := make(map[string]string)
+	for label, value := range t.Labels {
+		if _, found := defaults.Labels[label]; !found {
+			own[label] = value
+		}
+	}
+	validateLabels(name, own, p)
+	if t.Discover && t.Transport != TransportSSH {
+		p.add("%s: discover requires the %s transport", name, TransportSSH)
+	}
+	if t.CommandMode != CommandModeExec && t.CommandMode != CommandModeShell {
+		p.add("%s: unknown commandMode %q, must be %s or %s", name, t.CommandMode, CommandModeExec, CommandModeShell)
+	}
+	validateHostKeyPolicy(name, t.HostKeyPolicy, t.HostKeyFingerprint, p)
+	validateAlgorithms(name, t.Algorithms, global, p)
+	validateSecrets(name, p, t.SocksProxy)
+	if t.SocksProxy != "" {
+		if value, err := t.SocksProxy.Value(); err == nil {
+			// The URL is not printed, it may contain a password
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Host == "" {
+				p.add("%s: socksProxy must be a URL like socks5://host:port", name)
+			}
+		}
+	}
+
+	for i, hop := range t.ProxyJump {
+		hopName := fmt.Sprintf("%s: proxyJump[%d]", name, i)
+		if hop.Host == "" {
+			p.add("%s: host is required", hopName)
+		} else if err := validateAddress(hop.Host); err != nil {
+			p.add("%s: invalid host: %s", hopName, err)
+		}
+		hop.Credentials.validate(hopName, p)
+		validateHostKeyPolicy(hopName, hop.HostKeyPolicy, hop.HostKeyFingerprint, p)
+		validateAlgorithms(hopName, hop.Algorithms, global, p)
+	}
+}
+
+func (c *Credentials) validate(name string, p *problems) {
+	if c.Userid == "" {
+		p.add("%s: userid is required", name)
+	}
+	if !c.hasPassword() && c.PrivateKeyFile == "" && !c.UseAgent {
+		p.add("%s: one of password, passwordFile, passwordCommand, privateKeyFile or useAgent is required", name)
+	}
+	if c.PasswordCommand != nil && (len(c.PasswordCommand) == 0 || c.PasswordCommand[0] == "") {
+		p.add("%s: passwordCommand must not be empty", name)
+	}
+	for _, method := range c.AuthMethods {
+		if !contains(DefaultAuthMethods, method) {
+			p.add("%s: unknown authentication method %q", name, method)
+		}
+	}
+	validateSecrets(name, p, c.Password, c.PrivateKeyPassphrase)
+}
+
+func (s *SNMPConfig) validate(name string, p *problems) {
+	switch s.Version {
+	case "2c":
+		if s.Community == "" {
+			p.add("%s: snmp.community is required for SNMP version 2c", name)
+		}
+	case "3":
+		if s.Username == "" {
+			p.add("%s: snmp.username is required for SNMP version 3", name)
+		}
+	default:
+		p.add("%s: unknown snmp.version %q, must be 2c or 3", name, s.Version)
+	}
+	if s.SecurityLevel != "" {
+		if _, found := snmpSecurityLevels[s.SecurityLevel]; !found {
+			p.add("%s: unknown snmp.securityLevel %q", name, s.SecurityLevel)
+		}
+	}
+	if _, found := snmpAuthProtocols[strings.ToUpper(s.AuthProtocol)]; !found {
+		p.add("%s: unknown snmp.authProtocol %q", name, s.AuthProtocol)
+	}
+	if _, found := snmpPrivProtocols[strings.ToUpper(s.PrivProtocol)]; !found {
+		p.add("%s: unknown snmp.privProtocol %q", name, s.PrivProtocol)
+	}
+	validateSecrets(name, p, s.Community, s.AuthPassword, s.PrivPassword)
+}
+
+func validateLabels(name string, labels map[string]string, p *problems) {
+	var names []string
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	for _, label := range names {
+		switch {
+		case !labelNameRegexp.MatchString(label) || strings.HasPrefix(label, "__"):
+			p.add("%s: invalid label name %q", name, label)
+		case contains(ReservedLabelNames, label):
+			p.add("%s: label %q is set by the exporter", name, label)
+		}
+	}
+}
+
+func validateAlgorithms(name string, algorithms Algorithms, global Algorithms, p *problems) {
+	if reflect.DeepEqual(algorithms, global) {
+		return
+	}
+	if _, err := resolveAlgorithms(algorithms); err != nil {
+		p.add("%s: algorithms: %s", name, err)
+	}
+}
+
+func validateHostKeyPolicy(name string, policy string, fingerprint string, p *problems) {
+	switch policy {
+	case "", HostKeyPolicyStrict, HostKeyPolicyTOFU:
+	case HostKeyPolicyPinned:
+		if fingerprint == "" {
+			p.add("%s: hostKeyFingerprint is required with hostKeyPolicy pinned", name)
+		}
+	default:
+		p.add("%s: unknown hostKeyPolicy %q, must be %s, %s or %s", name, policy, HostKeyPolicyStrict, HostKeyPolicyTOFU, HostKeyPolicyPinned)
+	}
+}
+
+// validateSecrets checks that the environment variables referenced by the secrets are set
+func validateSecrets(name string, p *problems, secrets ...Secret) {
+	for _, secret := range secrets {
+		if _, err := secret.Value(); err != nil {
+			p.add("%s: %s", name, err)
+		}
+	}
+}
+
+// validateAddress accepts an IP address or host name, optionally followed by a port
+func validateAddress(address string) error {
+	_, _, err := SplitAddress(address, defaultSSHPort)
+	return err
+}
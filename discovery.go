@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// fabricMemberRegexp matches a switch of the fabricshow output and captures its Ethernet IP address,
+// the > marking the principal switch of the fabric and the switch name:
+//
+//	1: fffc01 10:00:88:94:71:61:5d:73 172.16.64.17    0.0.0.0        >"SAN1"
+var fabricMemberRegexp = regexp.MustCompile(`(?m)^\s*\d+:\s+[0-9a-fA-F]{6}\s+[0-9a-fA-F:]+\s+(\S+)\s+\S+\s+(>?)"(.*?)"`)
+
+// fabricMember is a switch found by the discovery
+type fabricMember struct {
+	Address string
+	Name    string
+	// Principal is set for the principal switch of the fabric
+	Principal bool
+}
+
+var discoveredTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "fabricos_exporter_discovered_targets",
+	Help: "Number of fabric members found by the last discovery run of a seed target.",
+}, []string{"seed"})
+
+// discoverLoop refreshes the members of the fabrics of all seed targets every interval until ctx is cancelled
+func discoverLoop(ctx context.Context, sc *safeConfig, connectionManager *connector.SSHConnectionManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		discoverAll(ctx, sc, connectionManager)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func discoverAll(ctx context.Context, sc *safeConfig, connectionManager *connector.SSHConnectionManager) {
+	changed := false
+	for _, seed := range sc.get().Targets {
+		if !seed.Discover {
+			continue
+		}
+		members, err := discover(ctx, seed, connectionManager)
+		if ctx.Err() != nil {
+			// The discovery was stopped, the seed may no longer be configured
+			return
+		}
+		if err != nil {
+			// The members found before are kept until the seed can be reached again
+			level.Error(logging.With("target", seed.IpAddress)).Log("msg", "Discovery failed", "err", err)
+			continue
+		}
+		level.Debug(logging.With("target", seed.IpAddress)).Log("msg", "Discovered the fabric members", "count", len(members), "members", fmt.Sprint(members))
+		discoveredTargets.WithLabelValues(seed.IpAddress).Set(float64(len(members)))
+		if sc.setDiscovered(seed.IpAddress, members) {
+			changed = true
+		}
+	}
+	if changed {
+		sc.targetsChanged(connectionManager)
+	}
+}
+
+// discover runs fabricshow on the seed target and returns the members of the fabric.
+// The seed itself is returned with its configured address.
+func discover(ctx context.Context, seed connector.Targets, connectionManager *connector.SSHConnectionManager) ([]fabricMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, seed.ConnectTimeout+seed.CommandTimeout)
+	defer cancel()
+
+	output, err := collector.RunCommand(ctx, connectionManager, seed, "discovery", "fabricshow")
+	if err != nil {
+		return nil, errors.Wrap(err, "executing fabricshow failed")
+	}
+	seedIPs, err := seedAddresses(ctx, seed.IpAddress)
+	if err != nil {
+		return nil, err
+	}
+	return parseFabricShow(output, seed.IpAddress, seedIPs), nil
+}
+
+// seedAddresses returns the IP addresses of the seed, a host name is resolved
+func seedAddresses(ctx context.Context, address string) (map[string]bool, error) {
+	host, _, err := connector.SplitAddress(address, 0)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return map[string]bool{host: true}, nil
+	}
+	resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving %s failed", host)
+	}
+	ips := make(map[string]bool)
+	for _, ip := range resolved {
+		ips[ip.IP.String()] = true
+	}
+	return ips, nil
+}
+
+// parseFabricShow returns the members listed by fabricshow. The seed is recognized by its Ethernet IP
+// address, one of seedIPs, and returned with seedAddress. Members without Ethernet IP address are skipped.
+func parseFabricShow(output string, seedAddress string, seedIPs map[string]bool) []fabricMember {
+	var members []fabricMember
+	for _, match := range fabricMemberRegexp.FindAllStringSubmatch(output, -1) {
+		host, _, err := connector.SplitAddress(match[1], 0)
+		if err != nil {
+			continue
+		}
+		member := fabricMember{Address: host, Name: match[3], Principal: match[2] == ">"}
+		if seedIPs[host] {
+			member.Address = seedAddress
+		} else if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
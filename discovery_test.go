@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+// fabricShow is the fabricshow output of a fabric whose principal switch is SAN1
+const fabricShow = `Switch ID   Worldwide Name           Enet IP Addr    FC IP Addr      Name
+-------------------------------------------------------------------------
+  1: fffc01 10:00:88:94:71:61:5d:73 172.16.64.17    0.0.0.0        >"SAN1"
+  2: fffc02 10:00:88:94:71:61:5d:74 172.16.64.18    0.0.0.0         "SAN2"
+  3: fffc03 10:00:00:05:1e:0c:1d:3a 0.0.0.0         0.0.0.0         "SAN3"
+ 10: fffc0a 10:00:00:05:1e:0c:1d:3b fd00::0:12      0.0.0.0         "SAN 4"
+
+The Fabric has 4 switches
+`
+
+func TestFabricMemberRegexp(t *testing.T) {
+	var got [][]string
+	for _, match := range fabricMemberRegexp.FindAllStringSubmatch(fabricShow, -1) {
+		got = append(got, match[1:])
+	}
+	expected := [][]string{
+		{"172.16.64.17", ">", "SAN1"},
+		{"172.16.64.18", "", "SAN2"},
+		{"0.0.0.0", "", "SAN3"},
+		{"fd00::0:12", "", "SAN 4"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("fabricMemberRegexp matched %q, want %q", got, expected)
+	}
+}
+
+func TestDiscoveredTargets(t *testing.T) {
+	sc := &safeConfig{config: &connector.Config{Targets: []connector.Targets{
+		{IpAddress: "172.16.64.17", Discover: true, Port: 2222, Labels: map[string]string{"fabric": "a"},
+			Credentials: connector.Credentials{Userid: "monitor"}},
+		{IpAddress: "172.16.64.18", Labels: map[string]string{"fabric": "own"}},
+	}}}
+
+	members := []fabricMember{
+		{Address: "172.16.64.17", Name: "SAN1"},
+		{Address: "172.16.64.18", Name: "SAN2"},
+		{Address: "172.16.64.19", Name: "SAN3"},
+	}
+	if !sc.setDiscovered("172.16.64.17", members) {
+		t.Error("expected the first discovery to change the targets")
+	}
+	if sc.setDiscovered("172.16.64.17", append([]fabricMember(nil), members...)) {
+		t.Error("expected the same members not to change the targets")
+	}
+
+	// configured targets take precedence, discovered ones are reached like the seed
+	targets := sc.targets()
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, got %+v", targets)
+	}
+	if targets[1].Labels["fabric"] != "own" {
+		t.Errorf("expected the configured target to keep its settings, got %+v", targets[1])
+	}
+	member := targets[2]
+	if member.IpAddress != "172.16.64.19" || member.Discover || member.Userid != "monitor" || member.Port != 2222 {
+		t.Errorf("expected the discovered member to be reached like the seed, got %+v", member)
+	}
+	if len(member.Labels) != 0 {
+		t.Errorf("expected the labels of the seed not to be passed on, got %v", member.Labels)
+	}
+
+	if name := sc.switchName("172.16.64.19"); name != "SAN3" {
+		t.Errorf("switchName() = %q, want SAN3", name)
+	}
+
+	if removed := sc.pruneDiscovered(); len(removed) != 0 {
+		t.Errorf("expected no seed to be pruned, got %v", removed)
+	}
+	sc.config = &connector.Config{Targets: []connector.Targets{{IpAddress: "172.16.64.17"}}}
+	if removed := sc.pruneDiscovered(); !reflect.DeepEqual(removed, []string{"172.16.64.17"}) {
+		t.Errorf("expected the seed which no longer discovers to be pruned, got %v", removed)
+	}
+	if n := len(sc.targets()); n != 1 {
+		t.Errorf("expected the discovered members to be removed, got %d targets", n)
+	}
+}
+
+func TestUpdateDiscovery(t *testing.T) {
+	m, err := connector.NewConnectionManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	seeds := &connector.Config{Targets: []connector.Targets{
+		{IpAddress: "127.0.0.1:1", Discover: true, ConnectTimeout: 100 * time.Millisecond, CommandTimeout: 100 * time.Millisecond},
+	}}
+	setConfig := func(sc *safeConfig, c *connector.Config) {
+		sc.mu.Lock()
+		sc.config = c
+		sc.mu.Unlock()
+	}
+
+	// the members of a seed which was removed are forgotten
+	sc := &safeConfig{config: seeds, discoveryInterval: time.Hour}
+	sc.setDiscovered("10.0.0.9", []fabricMember{{Address: "10.0.0.10", Name: "SAN9"}})
+	discoveredTargets.WithLabelValues("10.0.0.9").Set(1)
+	sc.updateDiscovery(m)
+	if sc.stopDiscovery == nil {
+		t.Fatal("expected the discovery to run with a seed target")
+	}
+	if name := sc.switchName("10.0.0.10"); name != "" {
+		t.Errorf("expected the members of the removed seed to be forgotten, got %q", name)
+	}
+	if discoveredTargets.DeleteLabelValues("10.0.0.9") {
+		t.Error("expected the member count of the removed seed to be deleted")
+	}
+
+	// the discovery stops once no seed is left
+	setConfig(sc, &connector.Config{Targets: []connector.Targets{{IpAddress: "10.0.0.1"}}})
+	sc.updateDiscovery(m)
+	if sc.stopDiscovery != nil {
+		t.Error("expected the discovery to stop without seed targets")
+	}
+
+	sc = &safeConfig{config: seeds}
+	sc.updateDiscovery(m)
+	if sc.stopDiscovery != nil {
+		t.Error("expected no discovery without interval")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// lastStatus returns the result of the last scrape of a target
+var lastStatus = collector.LastStatus
+
+// healthyHandler tells that the process is up
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Healthy.\n"))
+}
+
+// readyHandler tells whether the configuration is loaded and at least minTargets targets are reachable.
+// The switches are not connected to, the check only looks at the connection pool and the last scrapes.
+func readyHandler(connectionManager *connector.SSHConnectionManager, minTargets int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sc.get() == nil {
+			http.Error(w, "The configuration is not loaded.", http.StatusServiceUnavailable)
+			return
+		}
+		if minTargets <= 0 {
+			w.Write([]byte("Ready.\n"))
+			return
+		}
+
+		reachable := reachableTargets(connectionManager, sc.targets())
+		if reachable < minTargets {
+			level.Warn(logging.Base()).Log("msg", "Not ready, too few targets are reachable", "reachable", reachable, "required", minTargets)
+			http.Error(w, fmt.Sprintf("%d of the required %d targets are reachable.", reachable, minTargets), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "Ready, %d targets are reachable.\n", reachable)
+	}
+}
+
+// reachableTargets returns the number of targets whose last scrape succeeded or which have open pooled connections
+func reachableTargets(connectionManager *connector.SSHConnectionManager, targets []connector.Targets) int {
+	reachable := 0
+	for _, t := range targets {
+		if status, found := lastStatus(t.IpAddress); found && status.Success {
+			reachable++
+			continue
+		}
+		if t.Transport != connector.TransportSSH {
+			continue
+		}
+		if pool, found := connectionManager.PoolStatus(t); found && pool.Connections > 0 {
+			reachable++
+		}
+	}
+	return reachable
+}
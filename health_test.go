@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestHealthyHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthyHandler(w, httptest.NewRequest("GET", "/-/healthy", nil))
+	if w.Code != 200 {
+		t.Errorf("status %d, want 200", w.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	current, currentStatus := sc, lastStatus
+	defer func() { sc, lastStatus = current, currentStatus }()
+	connectionManager, err := connector.NewConnectionManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.Close()
+
+	// a switch which accepts connections but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	hung := l.Addr().String()
+
+	ready := func(minTargets int) int {
+		w := httptest.NewRecorder()
+		readyHandler(connectionManager, minTargets)(w, httptest.NewRequest("GET", "/-/ready", nil))
+		return w.Code
+	}
+
+	sc = &safeConfig{}
+	if code := ready(0); code != 503 {
+		t.Errorf("without configuration: status %d, want 503", code)
+	}
+
+	sc = &safeConfig{config: &connector.Config{Targets: []connector.Targets{
+		{IpAddress: hung, Transport: connector.TransportSSH, ConnectTimeout: time.Minute,
+			Credentials: connector.Credentials{Userid: "admin", Password: "secret"}},
+		{IpAddress: "192.0.2.2", Transport: connector.TransportSNMP},
+	}}}
+	statuses := map[string]collector.TargetStatus{}
+	lastStatus = func(target string) (collector.TargetStatus, bool) {
+		status, found := statuses[target]
+		return status, found
+	}
+	if code := ready(0); code != 200 {
+		t.Errorf("with configuration: status %d, want 200", code)
+	}
+
+	// the readiness check doesn't connect to the hung switch
+	start := time.Now()
+	if code := ready(1); code != 503 {
+		t.Errorf("without scrapes: status %d, want 503", code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the readiness check not to dial the targets, it took %s", d)
+	}
+
+	statuses[hung] = collector.TargetStatus{Target: hung, Success: false}
+	statuses["192.0.2.2"] = collector.TargetStatus{Target: "192.0.2.2", Success: true}
+	if code := ready(1); code != 200 {
+		t.Errorf("with a successful scrape: status %d, want 200", code)
+	}
+	if code := ready(2); code != 503 {
+		t.Errorf("with a failed scrape: status %d, want 503", code)
+	}
+}
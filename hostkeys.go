@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log/level"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+	"golang.org/x/crypto/ssh"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	hostKeysCmd        = kingpin.Command("hostkeys", "Manage the SSH host keys in the known_hosts file.")
+	hostKeysListCmd    = hostKeysCmd.Command("list", "List the trusted host keys.")
+	hostKeysTrustCmd   = hostKeysCmd.Command("trust", "Fetch the current host key of a switch and trust it, replacing a stored key of the same type.")
+	hostKeysTrustHost  = hostKeysTrustCmd.Arg("host", "Address of the switch, optionally with port.").Required().String()
+	hostKeysForgetCmd  = hostKeysCmd.Command("forget", "Remove all host keys of a switch.")
+	hostKeysForgetHost = hostKeysForgetCmd.Arg("host", "Address of the switch, optionally with port.").Required().String()
+)
+
+// runHostKeys runs the hostkeys subcommand selected on the command line
+func runHostKeys(command string, store *connector.HostKeyStore) error {
+	switch command {
+	case hostKeysListCmd.FullCommand():
+		entries, err := store.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tTYPE\tFINGERPRINT")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", strings.Join(entry.Hosts, ","), entry.KeyType, entry.Fingerprint)
+		}
+		return w.Flush()
+	case hostKeysTrustCmd.FullCommand():
+		target, err := trustTarget(*hostKeysTrustHost)
+		if err != nil {
+			return err
+		}
+		host, err := connector.CanonicalAddress(target.IpAddress, target.Port)
+		if err != nil {
+			return err
+		}
+		connectionManager, err := connector.NewConnectionManager(connectionManagerOptions(store)...)
+		if err != nil {
+			return err
+		}
+		defer connectionManager.Close()
+		key, err := connectionManager.FetchHostKey(target)
+		if err != nil {
+			return err
+		}
+		if err := store.Add(host, key); err != nil {
+			return err
+		}
+		fmt.Printf("Trusted %s key %s of %s\n", key.Type(), ssh.FingerprintSHA256(key), host)
+	case hostKeysForgetCmd.FullCommand():
+		host, err := connector.CanonicalAddress(*hostKeysForgetHost, 0)
+		if err != nil {
+			return err
+		}
+		removed, err := store.Remove(host)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d key(s) of %s\n", removed, host)
+	}
+	return nil
+}
+
+// trustTarget returns the configured target of the host, so that its proxies, jump hosts and algorithms
+// are used to fetch the host key. Hosts which are not configured are connected to directly.
+func trustTarget(host string) (connector.Targets, error) {
+	if err := sc.reload(*configFile, nil); err != nil {
+		level.Warn(logging.Base()).Log("msg", "Error loading the config file, the host is connected to directly", "host", host, "err", err)
+	} else if target, found := findTarget(sc.targets(), host); found {
+		return target, nil
+	}
+	if _, err := connector.CanonicalAddress(host, 0); err != nil {
+		return connector.Targets{}, err
+	}
+	return connector.Targets{IpAddress: host}, nil
+}
@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	commandOutputFile    *string
+	commandOutputMaxSize *int64
+
+	// commandOutput is the file the raw command output is appended to, opened on first use
+	commandOutput     *os.File
+	commandOutputSize int64
+	commandOutputMu   sync.Mutex
+)
+
+// commandOutputKey marks the line of CommandOutput, which the filter writes to the command output file
+type commandOutputKey struct{}
+
+func addCommandOutputFlags(a *kingpin.Application) {
+	commandOutputFile = a.Flag("log.command-output.file", "File the raw output of the commands run on targets with log level debug is written to (disabled if empty).").Default("").String()
+	commandOutputMaxSize = a.Flag("log.command-output.max-size", "Size in bytes after which the command output file is moved to <file>.1 and started anew.").Default("10485760").Int64()
+}
+
+// CommandOutput writes the raw output of a command to the command output file, if the logger
+// writes debug lines. The output is kept out of the log, where it would drown the other lines.
+func CommandOutput(l log.Logger, output string) {
+	if commandOutputFile == nil || *commandOutputFile == "" {
+		return
+	}
+	level.Debug(l).Log("msg", "command output", "bytes", len(output), commandOutputKey{}, output)
+}
+
+// commandOutput writes the output after a header line with the fields of the logger
+func (f *filter) commandOutput(keyvals []interface{}, output string) error {
+	var header bytes.Buffer
+	if err := formatLogger(&header, f.format).Log(keyvals...); err != nil {
+		return errors.Wrap(err, "error formatting the command output header")
+	}
+	record := header.String() + output
+	if len(output) > 0 && output[len(output)-1] != '\n' {
+		record += "\n"
+	}
+
+	commandOutputMu.Lock()
+	defer commandOutputMu.Unlock()
+	if err := writeCommandOutput(record); err != nil {
+		return f.next.Log(append(keyvals[:len(keyvals):len(keyvals)], "err", errors.Wrapf(err, "error writing the command output to %s", *commandOutputFile))...)
+	}
+	return nil
+}
+
+func writeCommandOutput(record string) error {
+	if commandOutput != nil && commandOutputSize+int64(len(record)) > *commandOutputMaxSize {
+		commandOutput.Close()
+		commandOutput = nil
+		if err := os.Rename(*commandOutputFile, *commandOutputFile+".1"); err != nil {
+			return errors.Wrap(err, "error rotating the file")
+		}
+	}
+	if commandOutput == nil {
+		f, err := os.OpenFile(*commandOutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		commandOutput = f
+		commandOutputSize = info.Size()
+	}
+	n, err := fmt.Fprint(commandOutput, record)
+	commandOutputSize += int64(n)
+	return err
+}
@@ -0,0 +1,199 @@
+// Package logging sets up the go-kit logger of the exporter with the promlog flags. Log lines carry
+// fields like the target, switch, collector and command, and the log level can be changed at runtime,
+// for all lines or only for the lines about single targets.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/promlog"
+	"github.com/prometheus/common/promlog/flag"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Levels are the valid log levels, from the most to the least verbose
+var Levels = []string{"debug", "info", "warn", "error"}
+
+var (
+	// config holds the --log.level and --log.format flags
+	config = &promlog.Config{}
+
+	// base is the logger without fields, the levels are checked by its filter
+	base   = New(os.Stderr, "logfmt")
+	baseMu sync.RWMutex
+
+	globalLevel  = 1
+	targetLevels = make(map[string]int)
+	levelsMu     sync.RWMutex
+
+	// timestampFormat is the millisecond UTC timestamp of promlog
+	timestampFormat = log.TimestampFormat(
+		func() time.Time { return time.Now().UTC() },
+		"2006-01-02T15:04:05.000Z07:00",
+	)
+)
+
+// AddFlags adds the promlog flags of the log level and format and the command output flags to the kingpin application
+func AddFlags(a *kingpin.Application) {
+	flag.AddFlags(a, config)
+	addCommandOutputFlags(a)
+	a.Action(func(*kingpin.ParseContext) error {
+		if err := SetLevel(config.Level.String()); err != nil {
+			return err
+		}
+		setBase(New(os.Stderr, config.Format.String()))
+		return nil
+	})
+}
+
+// New returns a logger writing to w in the format logfmt or json. Like the loggers of promlog, it adds
+// the timestamp and caller to every line, but filters the lines by the levels which are set at runtime.
+func New(w io.Writer, format string) log.Logger {
+	l := &filter{next: formatLogger(log.NewSyncWriter(w), format), format: format}
+	return log.With(l, "ts", timestampFormat, "caller", log.DefaultCaller)
+}
+
+func formatLogger(w io.Writer, format string) log.Logger {
+	if format == "json" {
+		return log.NewJSONLogger(w)
+	}
+	return log.NewLogfmtLogger(w)
+}
+
+func setBase(l log.Logger) {
+	baseMu.Lock()
+	defer baseMu.Unlock()
+	base = l
+}
+
+// Base returns the logger without fields
+func Base() log.Logger {
+	baseMu.RLock()
+	defer baseMu.RUnlock()
+	return base
+}
+
+// With returns a logger which adds the fields to every line. The target field selects the log level.
+func With(keyvals ...interface{}) log.Logger {
+	return log.With(Base(), keyvals...)
+}
+
+// SetLevel sets the log level of all targets which don't have their own
+func SetLevel(name string) error {
+	l, err := parseLevel(name)
+	if err != nil {
+		return err
+	}
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	globalLevel = l
+	return nil
+}
+
+// SetTargetLevel sets the log level of the lines about a target, an empty level resets it to the global one
+func SetTargetLevel(target string, name string) error {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	if name == "" {
+		delete(targetLevels, target)
+		return nil
+	}
+	l, err := parseLevel(name)
+	if err != nil {
+		return err
+	}
+	targetLevels[target] = l
+	return nil
+}
+
+// GetLevels returns the global log level and the levels of the targets which have their own
+func GetLevels() (string, map[string]string) {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+
+	targets := make(map[string]string, len(targetLevels))
+	for target, l := range targetLevels {
+		targets[target] = Levels[l]
+	}
+	return Levels[globalLevel], targets
+}
+
+// parseLevel returns the index of the level in Levels
+func parseLevel(name string) (int, error) {
+	for i, valid := range Levels {
+		if name == valid {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("unknown log level %q, must be one of %s", name, strings.Join(Levels, ", "))
+}
+
+// enabled tells whether lines of the level about the target are written
+func enabled(target string, name string) bool {
+	l, err := parseLevel(name)
+	if err != nil {
+		return true
+	}
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+
+	if targetLevel, found := targetLevels[target]; found && target != "" {
+		return l >= targetLevel
+	}
+	return l >= globalLevel
+}
+
+// filter drops the lines below the level of their target and writes the command output to its file.
+// Lines without level are always written.
+type filter struct {
+	next   log.Logger
+	format string
+}
+
+func (f *filter) Log(keyvals ...interface{}) error {
+	var lvl level.Value
+	var target string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		switch keyvals[i] {
+		case level.Key():
+			lvl, _ = keyvals[i+1].(level.Value)
+		case "target":
+			target = fmt.Sprint(keyvals[i+1])
+		case commandOutputKey{}:
+			if lvl == nil || !enabled(target, lvl.String()) {
+				return nil
+			}
+			return f.commandOutput(append(keyvals[:i:i], keyvals[i+2:]...), keyvals[i+1].(string))
+		}
+	}
+	if lvl != nil && !enabled(target, lvl.String()) {
+		return nil
+	}
+	return f.next.Log(keyvals...)
+}
+
+// loggerKey is the context key of the logger of a collection
+type loggerKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, l log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of the context, the logger without fields if there is none
+func FromContext(ctx context.Context) log.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(log.Logger); ok {
+		return l
+	}
+	return Base()
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// capture writes the log to a buffer in the format until the returned function is called
+func capture(format string) (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	old := Base()
+	setBase(New(buf, format))
+	return buf, func() {
+		setBase(old)
+		SetLevel("info")
+		levelsMu.Lock()
+		targetLevels = make(map[string]int)
+		levelsMu.Unlock()
+	}
+}
+
+func TestTargetLevels(t *testing.T) {
+	buf, restore := capture("logfmt")
+	defer restore()
+
+	switch1 := With("target", "192.0.2.1")
+	switch2 := With("target", "192.0.2.2")
+	if err := SetTargetLevel("192.0.2.1", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	level.Debug(switch1).Log("msg", "debug of switch1")
+	level.Debug(switch2).Log("msg", "debug of switch2")
+	level.Debug(Base()).Log("msg", "global debug")
+	level.Info(switch2).Log("msg", "info of switch2")
+
+	logged := buf.String()
+	for _, expected := range []string{`msg="debug of switch1"`, `msg="info of switch2"`, "target=192.0.2.1", "level=debug", "caller=logging_test.go:", "ts="} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected %q in the log, got %q", expected, logged)
+		}
+	}
+	for _, unexpected := range []string{"debug of switch2", "global debug"} {
+		if strings.Contains(logged, unexpected) {
+			t.Errorf("expected %q not to be logged", unexpected)
+		}
+	}
+
+	global, targets := GetLevels()
+	if global != "info" || len(targets) != 1 || targets["192.0.2.1"] != "debug" {
+		t.Errorf("GetLevels() = %s, %v", global, targets)
+	}
+
+	// the target level overrides the global one in both directions
+	SetTargetLevel("192.0.2.2", "error")
+	SetLevel("debug")
+	buf.Reset()
+	level.Warn(log.With(switch2, "collector", "sensor")).Log("msg", "warning of switch2")
+	level.Debug(Base()).Log("msg", "global debug")
+	if logged := buf.String(); strings.Contains(logged, "warning of switch2") || !strings.Contains(logged, "global debug") {
+		t.Errorf("unexpected log %q", logged)
+	}
+
+	SetTargetLevel("192.0.2.2", "")
+	if _, targets := GetLevels(); len(targets) != 1 {
+		t.Errorf("expected the level of the target to be reset, got %v", targets)
+	}
+	for _, name := range []string{"trace", "warning", "fatal", ""} {
+		if err := SetLevel(name); err == nil {
+			t.Errorf("expected the level %q to be rejected", name)
+		}
+	}
+	if err := SetTargetLevel("192.0.2.2", "verbose"); err == nil {
+		t.Error("expected an unknown target level to be rejected")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	buf, restore := capture("json")
+	defer restore()
+
+	level.Info(With("collector", "sensor")).Log("msg", "collected")
+	logged := buf.String()
+	for _, expected := range []string{`"collector":"sensor"`, `"msg":"collected"`, `"level":"info"`, `"caller":"logging_test.go:`} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected %q in the JSON line, got %q", expected, logged)
+		}
+	}
+}
+
+func TestCommandOutputRotation(t *testing.T) {
+	buf, restore := capture("logfmt")
+	defer restore()
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "commands.log")
+	maxSize := int64(300)
+	commandOutputFile, commandOutputMaxSize = &file, &maxSize
+	defer func() {
+		commandOutputMu.Lock()
+		if commandOutput != nil {
+			commandOutput.Close()
+			commandOutput = nil
+		}
+		commandOutputMu.Unlock()
+		commandOutputFile, commandOutputMaxSize = nil, nil
+	}()
+
+	l := With("target", "192.0.2.1", "command", "sensorshow")
+	CommandOutput(l, "not written at level info\n")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected no output below level debug, got %v", err)
+	}
+
+	SetTargetLevel("192.0.2.1", "debug")
+	CommandOutput(l, "sensor 1: (Temperature) is Ok, value is 38 C")
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "command=sensorshow") || !strings.HasSuffix(string(content), "value is 38 C\n") {
+		t.Errorf("unexpected command output %q", content)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected the command output to be kept out of the log, got %q", buf.String())
+	}
+
+	// the file is moved aside once it would grow beyond the maximum size
+	CommandOutput(l, strings.Repeat("x", 200)+"\n")
+	rotated, err := ioutil.ReadFile(file + ".1")
+	if err != nil {
+		t.Fatalf("expected the file to be rotated: %v", err)
+	}
+	if string(rotated) != string(content) {
+		t.Errorf("expected the rotated file to keep the first output, got %q", rotated)
+	}
+	content, _ = ioutil.ReadFile(file)
+	if !strings.Contains(string(content), strings.Repeat("x", 200)) || strings.Contains(string(content), "38 C") {
+		t.Errorf("expected the new file to start with the last output, got %q", content)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// logLevels is the response of /-/log-level
+type logLevels struct {
+	Level   string            `json:"level"`
+	Targets map[string]string `json:"targets"`
+}
+
+// logLevelHandler shows the global log level and the levels of single targets. With allowChanges,
+// a POST with the level and target form values changes them. Without target the global level is set,
+// an empty level or "default" resets a target to the global level.
+func logLevelHandler(allowChanges bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if !allowChanges {
+				http.Error(w, "Changing the log level requires --web.enable-lifecycle.", http.StatusForbidden)
+				return
+			}
+			if err := setLogLevel(r.FormValue("target"), r.FormValue("level")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			http.Error(w, "This endpoint requires a GET or POST request.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		global, targets := logging.GetLevels()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logLevels{Level: global, Targets: targets}); err != nil {
+			level.Error(logging.Base()).Log("msg", "Writing the log level response failed", "err", err)
+		}
+	}
+}
+
+// setLogLevel sets the global log level or the one of a target, which is looked up like the target URL parameter
+func setLogLevel(reqTarget string, name string) error {
+	if reqTarget == "" {
+		if err := logging.SetLevel(name); err != nil {
+			return err
+		}
+		level.Info(logging.Base()).Log("msg", "Log level changed", "level", name)
+		return nil
+	}
+
+	target, found := findTarget(sc.targets(), reqTarget)
+	if !found {
+		return errors.Errorf("The target '%s' is not defined in the configuration file", reqTarget)
+	}
+	if name == "default" {
+		name = ""
+	}
+	if err := logging.SetTargetLevel(target.IpAddress, name); err != nil {
+		return err
+	}
+	if name == "" {
+		level.Info(logging.With("target", target.IpAddress)).Log("msg", "Log level of the target reset to the global level")
+	} else {
+		level.Info(logging.With("target", target.IpAddress)).Log("msg", "Log level of the target changed", "level", name)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	current := sc
+	defer func() { sc = current }()
+	sc = &safeConfig{config: &connector.Config{Targets: []connector.Targets{
+		{IpAddress: "192.0.2.1", Aliases: []string{"fra1-edge-a"}},
+	}}}
+	defer logging.SetLevel("info")
+	defer logging.SetTargetLevel("192.0.2.1", "")
+
+	request := func(method string, form url.Values) (int, logLevels) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/-/log-level", strings.NewReader(form.Encode()))
+		if method == "POST" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		logLevelHandler(true)(w, r)
+		var levels logLevels
+		json.Unmarshal(w.Body.Bytes(), &levels)
+		return w.Code, levels
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		form    url.Values
+		code    int
+		level   string
+		targets map[string]string
+	}{
+		{"show", "GET", nil, 200, "info", map[string]string{}},
+		{"global level", "POST", url.Values{"level": {"warn"}}, 200, "warn", map[string]string{}},
+		{"target by alias", "POST", url.Values{"target": {"fra1-edge-a"}, "level": {"debug"}}, 200, "warn", map[string]string{"192.0.2.1": "debug"}},
+		{"unknown target", "POST", url.Values{"target": {"192.0.2.9"}, "level": {"debug"}}, 400, "", nil},
+		{"unknown level", "POST", url.Values{"level": {"verbose"}}, 400, "", nil},
+		{"reset target", "POST", url.Values{"target": {"192.0.2.1"}, "level": {"default"}}, 200, "warn", map[string]string{}},
+		{"other method", "DELETE", nil, 405, "", nil},
+	}
+	for _, test := range tests {
+		code, levels := request(test.method, test.form)
+		if code != test.code {
+			t.Errorf("%s: status %d, want %d", test.name, code, test.code)
+			continue
+		}
+		if code != 200 {
+			continue
+		}
+		if levels.Level != test.level || len(levels.Targets) != len(test.targets) {
+			t.Errorf("%s: levels = %+v, want %s %v", test.name, levels, test.level, test.targets)
+		}
+		for target, level := range test.targets {
+			if levels.Targets[target] != level {
+				t.Errorf("%s: level of %s = %s, want %s", test.name, target, levels.Targets[target], level)
+			}
+		}
+	}
+
+	// changes require --web.enable-lifecycle
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/-/log-level", strings.NewReader("level=debug"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	logLevelHandler(false)(w, r)
+	if w.Code != 403 {
+		t.Errorf("without lifecycle: status %d, want 403", w.Code)
+	}
+}
@@ -1,85 +1,162 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	stdlog "log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gorilla/csrf"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
 	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
 	configFile             = kingpin.Flag("config.file", "Path to configuration file.").Default("fabricos.yaml").String()
+	configCheck            = kingpin.Flag("config.check", "Check the configuration file, print every problem found and exit.").Default("false").Bool()
 	metricsPath            = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 	listenAddress          = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":9879").String()
+	webConfigFile          = kingpin.Flag("web.config.file", "Path to the configuration file that can enable TLS or authentication.").Default("").String()
 	disableExporterMetrics = kingpin.Flag("web.disable-exporter-metrics", "Exclude metrics about the exporter itself (promhttp_*, process_*, go_*).").Default("true").Bool()
-	cfg                    *connector.Config
+	sshIdleTimeout         = kingpin.Flag("ssh.idle-timeout", "Close pooled SSH connections which were not used for this long (0 keeps them open).").Default("5m").Duration()
+	sshMaxConnsPerHost     = kingpin.Flag("ssh.max-connections-per-host", "Maximum number of pooled SSH connections to one switch.").Default("1").Int()
+	sshKeepAliveInterval   = kingpin.Flag("ssh.keepalive-interval", "Interval of the keepalive requests sent on pooled SSH connections.").Default("10s").Duration()
+	sshKeepAliveTimeout    = kingpin.Flag("ssh.keepalive-timeout", "Time after which a pooled SSH connection without keepalive reply is considered dead.").Default("15s").Duration()
+	sshReconnectInterval   = kingpin.Flag("ssh.reconnect-interval", "Interval between attempts to re-establish a lost SSH connection.").Default("30s").Duration()
+	sshKnownHostsFile      = kingpin.Flag("ssh.known-hosts-file", "Path of the known_hosts file the SSH host keys are verified against.").Default("/var/lib/fabric-os-exporter/known_hosts").String()
+	sshHostKeyPolicy       = kingpin.Flag("ssh.host-key-policy", "Host key policy of targets which don't set hostKeyPolicy: strict, tofu or pinned.").Default(connector.HostKeyPolicyTOFU).Enum(connector.HostKeyPolicyStrict, connector.HostKeyPolicyTOFU, connector.HostKeyPolicyPinned)
+	sshAuthMethods         = kingpin.Flag("ssh.auth-methods", "Comma separated order in which the SSH authentication methods are tried, unless a target sets authMethods.").Default(strings.Join(connector.DefaultAuthMethods, ",")).String()
+	scrapeTimeoutOffset    = kingpin.Flag("web.scrape-timeout-offset", "Offset subtracted from the scrape timeout sent by Prometheus, leaving time to send the response.").Default("500ms").Duration()
+	defaultScrapeTimeout   = kingpin.Flag("web.default-scrape-timeout", "Scrape timeout used when the request doesn't send X-Prometheus-Scrape-Timeout-Seconds (0 disables it).").Default("10s").Duration()
+	serveCmd               = kingpin.Command("serve", "Run the exporter.").Default()
+	enableLifecycle        = kingpin.Flag("web.enable-lifecycle", "Enable reloading the configuration via HTTP POST to /-/reload and changing the log levels via HTTP POST to /-/log-level.").Default("false").Bool()
+	readyMinTargets        = kingpin.Flag("web.ready-min-targets", "Number of targets which must be reachable through the SSH connection pool for /-/ready to succeed (0 only requires a loaded configuration).").Default("0").Int()
+	adHocTargets           = kingpin.Flag("web.adhoc-targets", "Scrape targets which are not configured with the credential profile given in the auth URL parameter.").Default("false").Bool()
+	discoveryInterval      = kingpin.Flag("discovery.refresh-interval", "Interval at which the fabric members of the targets with discover: true are refreshed.").Default("5m").Duration()
+	sc                     = &safeConfig{}
 )
 
 type handler struct {
 	// exporterMetricsRegistry is a separate registry for the metrics about the exporter itself.
 	exporterMetricsRegistry *prometheus.Registry
 	includeExporterMetrics  bool
-	// maxRequests             int
+	connectionManager       *connector.SSHConnectionManager
 }
 
 func main() {
 	r := mux.NewRouter()
-	CSRF := csrf.Protect([]byte("fabric-exporter-32-byte-auth-key"))
 	// Parse flags.
-	log.AddFlags(kingpin.CommandLine)
+	logging.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("fabric_os_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	logger := logging.Base()
+
+	hostKeys := connector.NewHostKeyStore(*sshKnownHostsFile)
+	if command != serveCmd.FullCommand() {
+		if err := runHostKeys(command, hostKeys); err != nil {
+			level.Error(logger).Log("err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *configCheck {
+		os.Exit(checkConfig(*configFile))
+	}
 
 	//Bail early if the config is bad.
-	log.Infoln("Loading config from", *configFile)
-	// var err error
-	c, err := connector.GetConfig(*configFile)
-	if err != nil {
-		log.Fatalf("Error parsing config file: %s", err)
+	level.Info(logger).Log("msg", "Loading config", "file", *configFile)
+	if err := sc.reload(*configFile, nil); err != nil {
+		level.Error(logger).Log("msg", "Error parsing config file", "err", err)
+		os.Exit(1)
 	}
-	cfg = c
 
-	log.Infoln("Starting fabric_os_exporter", version.Info())
-	log.Infoln("Build context", version.BuildContext())
+	level.Info(logger).Log("msg", "Starting fabric_os_exporter", "version", version.Info())
+	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
+
+	connectionManager, err := connector.NewConnectionManager(connectionManagerOptions(hostKeys)...)
+	if err != nil {
+		level.Error(logger).Log("msg", "Couldn't initialize connection manager", "err", err)
+		os.Exit(1)
+	}
+	defer connectionManager.Close()
 
 	// Launch http services
-	r.Handle(*metricsPath, newHandler(!*disableExporterMetrics))
+	r.Handle(*metricsPath, newHandler(!*disableExporterMetrics, connectionManager))
+
+	r.HandleFunc("/sd", sdHandler)
+	r.HandleFunc("/-/healthy", healthyHandler)
+	r.Handle("/-/ready", readyHandler(connectionManager, *readyMinTargets))
+	r.Handle("/-/log-level", sameOrigin(logLevelHandler(*enableLifecycle)))
+	r.Handle("/", statusHandler(connectionManager))
 
-	r.HandleFunc("/", rootHandler)
+	sc.discoveryInterval = *discoveryInterval
+	sc.updateDiscovery(connectionManager)
+	collector.UpdatePolling(sc.targets(), connectionManager)
+	go reloadOnSignal(sc, *configFile, connectionManager)
 
-	log.Infof("Listening for %s on %s\n", *metricsPath, *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, CSRF(r)))
+	if *enableLifecycle {
+		r.Handle("/-/reload", sameOrigin(reloadHandler(sc, *configFile, connectionManager)))
+	}
+
+	level.Info(logger).Log("msg", "Listening", "path", *metricsPath, "address", *listenAddress)
+	if err := listen(*listenAddress, *webConfigFile, r); err != nil {
+		level.Error(logger).Log("err", err)
+		os.Exit(1)
+	}
 }
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "GET" {
-		w.Write([]byte(`<html>
-		<head><title>fabric os exporter</title></head>
-		<body>
-			<h1>fabric os exporter</h1>
-			<p><a href='` + *metricsPath + `'>Metrics</a></p>
-		</body>
-	</html>`))
-	} else {
-		http.Error(w, "403 Forbidden", 403)
+// connectionManagerOptions returns the settings of the SSH connection manager given on the command line
+func connectionManagerOptions(hostKeys *connector.HostKeyStore) []connector.Option {
+	return []connector.Option{
+		connector.WithIdleTimeout(*sshIdleTimeout),
+		connector.WithMaxConnectionsPerHost(*sshMaxConnsPerHost),
+		connector.WithKeepAliveInterval(*sshKeepAliveInterval),
+		connector.WithKeepAliveTimeout(*sshKeepAliveTimeout),
+		connector.WithReconnectInterval(*sshReconnectInterval),
+		connector.WithAuthMethods(strings.Split(*sshAuthMethods, ",")),
+		connector.WithHostKeyStore(hostKeys),
+		connector.WithHostKeyPolicy(*sshHostKeyPolicy),
+	}
+}
+
+// checkConfig prints the problems of the configuration file and returns the exit code
+func checkConfig(filename string) int {
+	_, err := connector.GetConfig(filename)
+	if configErr, ok := err.(*connector.ConfigError); ok {
+		for _, problem := range configErr.Problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, problem)
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+		return 1
 	}
+	fmt.Printf("%s is valid\n", filename)
+	return 0
 }
 
-func newHandler(includeExporterMetrics bool) *handler {
+func newHandler(includeExporterMetrics bool, connectionManager *connector.SSHConnectionManager) *handler {
 	h := &handler{
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporterMetrics,
-		// maxRequests:             maxRequests,
+		connectionManager:       connectionManager,
 	}
+	h.exporterMetricsRegistry.MustRegister(connectionManager, configReloadSuccess, configReloadSeconds, discoveredTargets)
+	h.exporterMetricsRegistry.MustRegister(collector.ExporterMetrics()...)
 	if h.includeExporterMetrics {
 		h.exporterMetricsRegistry.MustRegister(
 			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
@@ -98,9 +175,18 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 400)
 			return
 		} else {
-			handler, err := h.innerHandler(targets...)
+			release, err := collector.Admit(len(targets))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			defer release()
+
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+			handler, err := h.innerHandler(ctx, targets...)
 			if err != nil {
-				log.Warnln("Couldn't create  metrics handler:", err)
+				level.Warn(logging.Base()).Log("msg", "Couldn't create metrics handler", "err", err)
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte(fmt.Sprintf("Couldn't create  metrics handler: %s", err)))
 				return
@@ -113,22 +199,45 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
-func (h *handler) innerHandler(targets ...connector.Targets) (http.Handler, error) {
 
-	registry := prometheus.NewRegistry()
-	sc, err := collector.NewFabricOSCollector(targets) //new a Fabric OS Collector
-	if err != nil {
-		log.Fatalf("Couldn't create collector: %s", err)
+// scrapeContext derives the deadline of a scrape from the timeout Prometheus sends with the request
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := *defaultScrapeTimeout
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			level.Warn(logging.Base()).Log("msg", "Invalid X-Prometheus-Scrape-Timeout-Seconds header", "value", v, "err", err)
+		} else {
+			timeout = time.Duration(seconds*float64(time.Second)) - *scrapeTimeoutOffset
+			if timeout <= 0 {
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+		}
 	}
-	if err := registry.Register(sc); err != nil {
-		return nil, fmt.Errorf("couldn't register Fabric collector: %s", err)
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+func (h *handler) innerHandler(ctx context.Context, targets ...connector.Targets) (http.Handler, error) {
+
+	registry := prometheus.NewRegistry()
+	// The static labels of the targets are added by a collector per label set
+	for _, group := range collector.GroupByLabels(targets) {
+		sc, err := collector.NewFabricOSCollector(ctx, group.Targets, h.connectionManager) //new a Fabric OS Collector
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create collector: %s", err)
+		}
+		if err := prometheus.WrapRegistererWith(group.Labels, registry).Register(sc); err != nil {
+			return nil, fmt.Errorf("couldn't register Fabric collector: %s", err)
+		}
 	}
 	handler := promhttp.HandlerFor(
 		prometheus.Gatherers{h.exporterMetricsRegistry, registry},
 		promhttp.HandlerOpts{
-			ErrorLog:      log.NewErrorLogger(),
+			ErrorLog:      stdlog.New(log.NewStdlibAdapter(level.Error(logging.Base())), "", 0),
 			ErrorHandling: promhttp.ContinueOnError,
-			// MaxRequestsInFlight: h.maxRequests,
 		},
 	)
 	if h.includeExporterMetrics {
@@ -142,17 +251,42 @@ func (h *handler) innerHandler(targets ...connector.Targets) (http.Handler, erro
 }
 
 func targetsForRequest(r *http.Request) ([]connector.Targets, error) {
+	config := sc.get()
+	targets, err := selectTargets(config, sc.targets(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	moduleName := r.URL.Query().Get("module")
+	if moduleName == "" {
+		return targets, nil
+	}
+	module, found := config.Modules[moduleName]
+	if !found {
+		return nil, fmt.Errorf("The module '%s' is not defined in the configuration file", moduleName)
+	}
+	withModule := make([]connector.Targets, len(targets))
+	for i, t := range targets {
+		withModule[i] = module.Apply(t)
+	}
+	return withModule, nil
+}
+
+func selectTargets(config *connector.Config, targets []connector.Targets, r *http.Request) ([]connector.Targets, error) {
 	reqTarget := r.URL.Query().Get("target")
-	// var targets []string
 	if reqTarget == "" {
-		// targets = strings.Split(*sshHosts, ",")
-		return cfg.Targets, nil
+		return targets, nil
 	}
 
-	for _, t := range cfg.Targets {
-		if t.IpAddress == reqTarget {
-			return []connector.Targets{t}, nil
+	if t, found := findTarget(targets, reqTarget); found {
+		return []connector.Targets{t}, nil
+	}
+	if auth := r.URL.Query().Get("auth"); auth != "" && *adHocTargets {
+		t, err := config.AdHocTarget(reqTarget, auth)
+		if err != nil {
+			return nil, fmt.Errorf("The target '%s' can't be scraped: %s", reqTarget, err)
 		}
+		return []connector.Targets{t}, nil
 	}
 
 	return nil, fmt.Errorf("The target '%s' os not defined in the configuration file", reqTarget)
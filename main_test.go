@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{"valid", validConfig, 0},
+		{"invalid", invalidConfig, 1},
+		{"unknown key", validConfig + "  snmpp: {}\n", 1},
+		{"malformed", "targets: [", 1},
+	}
+	for _, test := range tests {
+		filename := writeConfig(t, test.content)
+		if code := checkConfig(filename); code != test.expected {
+			t.Errorf("%s: checkConfig() = %d, want %d", test.name, code, test.expected)
+		}
+		os.Remove(filename)
+	}
+	if code := checkConfig("does-not-exist.yaml"); code != 1 {
+		t.Errorf("missing file: checkConfig() = %d, want 1", code)
+	}
+}
+
+func TestTargetsForRequest(t *testing.T) {
+	filename := writeConfig(t, validConfig+`
+modules:
+  light:
+    collectors: [uptime]
+    fullMetrics: false
+`)
+	defer os.Remove(filename)
+	current := sc
+	sc = &safeConfig{}
+	defer func() { sc = current }()
+	if err := sc.reload(filename, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+		fails    bool
+	}{
+		{"", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"?target=10.0.0.2", []string{"10.0.0.2"}, false},
+		{"?target=10.0.0.3", nil, true},
+		{"?module=light", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"?target=10.0.0.1&module=light", []string{"10.0.0.1"}, false},
+		{"?module=heavy", nil, true},
+	}
+	for _, test := range tests {
+		targets, err := targetsForRequest(httptest.NewRequest("GET", "/metrics"+test.query, nil))
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		var addresses []string
+		for _, target := range targets {
+			addresses = append(addresses, target.IpAddress)
+		}
+		if !reflect.DeepEqual(addresses, test.expected) {
+			t.Errorf("%s: targets = %v, want %v", test.query, addresses, test.expected)
+		}
+	}
+
+	// the module replaces the collection settings without changing the configuration
+	targets, _ := targetsForRequest(httptest.NewRequest("GET", "/metrics?module=light", nil))
+	if !reflect.DeepEqual(targets[0].Collectors, []string{"uptime"}) || targets[0].FullMetrics == nil || *targets[0].FullMetrics {
+		t.Errorf("expected the settings of the module, got %+v", targets[0])
+	}
+	if len(sc.get().Targets[0].Collectors) == 1 {
+		t.Error("expected the configured targets to stay unchanged")
+	}
+}
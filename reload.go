@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fabricos_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fabricos_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// safeConfig holds the configuration, which is swapped as a whole on reload, and the discovered targets
+type safeConfig struct {
+	config *connector.Config
+	// discovered are the fabric members found by each seed target
+	discovered map[string][]fabricMember
+	mu         sync.RWMutex
+	// reloadMu serializes reloads triggered by SIGHUP and the web endpoint
+	reloadMu sync.Mutex
+
+	// discoveryInterval is the interval of the discovery, which only runs while there are seed targets
+	discoveryInterval time.Duration
+	stopDiscovery     context.CancelFunc
+	discoveryMu       sync.Mutex
+}
+
+func (sc *safeConfig) get() *connector.Config {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	return sc.config
+}
+
+// targets returns the configured targets followed by the discovered ones.
+// A discovered switch is reached like its seed target, targets which are configured take precedence.
+func (sc *safeConfig) targets() []connector.Targets {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	targets := append([]connector.Targets(nil), sc.config.Targets...)
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		seen[t.IpAddress] = true
+	}
+	for _, seed := range sc.config.Targets {
+		if !seed.Discover {
+			continue
+		}
+		for _, m := range sc.discovered[seed.IpAddress] {
+			if seen[m.Address] {
+				continue
+			}
+			seen[m.Address] = true
+			targets = append(targets, sc.config.DiscoveredTarget(seed, m.Address))
+		}
+	}
+	return targets
+}
+
+// setDiscovered replaces the fabric members found by a seed target and tells whether they changed
+func (sc *safeConfig) setDiscovered(seed string, members []fabricMember) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.discovered == nil {
+		sc.discovered = make(map[string][]fabricMember)
+	}
+	if reflect.DeepEqual(sc.discovered[seed], members) {
+		return false
+	}
+	sc.discovered[seed] = members
+	return true
+}
+
+// switchName returns the name of a switch found by the discovery
+func (sc *safeConfig) switchName(address string) string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	for _, members := range sc.discovered {
+		for _, m := range members {
+			if m.Address == address {
+				return m.Name
+			}
+		}
+	}
+	return ""
+}
+
+// principal tells whether the discovery found the switch to be the principal switch of its fabric
+func (sc *safeConfig) principal(address string) bool {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	for _, members := range sc.discovered {
+		for _, m := range members {
+			if m.Address == address {
+				return m.Principal
+			}
+		}
+	}
+	return false
+}
+
+// pruneDiscovered forgets the fabric members of seeds which are no longer configured and returns those seeds
+func (sc *safeConfig) pruneDiscovered() []string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	seeds := make(map[string]bool)
+	for _, t := range sc.config.Targets {
+		if t.Discover {
+			seeds[t.IpAddress] = true
+		}
+	}
+	var removed []string
+	for seed := range sc.discovered {
+		if !seeds[seed] {
+			delete(sc.discovered, seed)
+			removed = append(removed, seed)
+		}
+	}
+	return removed
+}
+
+// reload loads and validates the configuration file. Only a valid configuration replaces the current one,
+// the connections to targets which were removed or changed are closed afterwards.
+func (sc *safeConfig) reload(filename string, connectionManager *connector.SSHConnectionManager) error {
+	sc.reloadMu.Lock()
+	defer sc.reloadMu.Unlock()
+
+	c, err := connector.GetConfig(filename)
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return errors.Wrapf(err, "error loading config file %s", filename)
+	}
+
+	sc.mu.Lock()
+	sc.config = c
+	sc.mu.Unlock()
+
+	if connectionManager != nil {
+		sc.updateDiscovery(connectionManager)
+		sc.targetsChanged(connectionManager)
+	}
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	return nil
+}
+
+// targetsChanged closes the connections to the targets which were removed or changed and
+// updates the targets polled in the background
+func (sc *safeConfig) targetsChanged(connectionManager *connector.SSHConnectionManager) {
+	targets := sc.targets()
+	connectionManager.Retain(targets)
+	collector.UpdatePolling(targets, connectionManager)
+}
+
+// updateDiscovery starts the discovery once the configuration has seed targets and stops it when none are
+// left. The members of seeds which are no longer configured are forgotten.
+func (sc *safeConfig) updateDiscovery(connectionManager *connector.SSHConnectionManager) {
+	for _, seed := range sc.pruneDiscovered() {
+		discoveredTargets.DeleteLabelValues(seed)
+	}
+	seeds := false
+	for _, t := range sc.get().Targets {
+		seeds = seeds || t.Discover
+	}
+
+	sc.discoveryMu.Lock()
+	defer sc.discoveryMu.Unlock()
+	switch {
+	case seeds && sc.stopDiscovery == nil && sc.discoveryInterval > 0:
+		ctx, cancel := context.WithCancel(context.Background())
+		sc.stopDiscovery = cancel
+		go discoverLoop(ctx, sc, connectionManager, sc.discoveryInterval)
+	case !seeds && sc.stopDiscovery != nil:
+		sc.stopDiscovery()
+		sc.stopDiscovery = nil
+	}
+}
+
+// reloadOnSignal reloads the configuration whenever the process receives SIGHUP
+func reloadOnSignal(sc *safeConfig, filename string, connectionManager *connector.SSHConnectionManager) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		level.Info(logging.Base()).Log("msg", "Reloading config", "file", filename)
+		if err := sc.reload(filename, connectionManager); err != nil {
+			level.Error(logging.Base()).Log("msg", "Error reloading config", "err", err)
+			continue
+		}
+		level.Info(logging.Base()).Log("msg", "Config reloaded")
+	}
+}
+
+// reloadHandler reloads the configuration on POST /-/reload
+func reloadHandler(sc *safeConfig, filename string, connectionManager *connector.SSHConnectionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+			return
+		}
+		level.Info(logging.Base()).Log("msg", "Reloading config", "file", filename)
+		if err := sc.reload(filename, connectionManager); err != nil {
+			level.Error(logging.Base()).Log("msg", "Error reloading config", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		level.Info(logging.Base()).Log("msg", "Config reloaded")
+	}
+}
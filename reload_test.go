@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const validConfig = `
+targets:
+- ipAddress: 10.0.0.1
+  userid: admin
+  password: secret
+- ipAddress: 10.0.0.2
+  userid: admin
+  password: secret
+`
+
+const invalidConfig = `
+targets:
+- ipAddress: 10.0.0.1
+  userid: admin
+  password: secret
+  transport: telnet
+`
+
+// writeConfig writes the content to a temporary configuration file
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "config*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestReload(t *testing.T) {
+	filename := writeConfig(t, validConfig)
+	defer os.Remove(filename)
+	sc := &safeConfig{}
+
+	if err := sc.reload(filename, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sc.get().Targets); n != 2 {
+		t.Fatalf("expected 2 targets, got %d", n)
+	}
+	if v := testutil.ToFloat64(configReloadSuccess); v != 1 {
+		t.Errorf("expected the reload to be successful, got %v", v)
+	}
+
+	// an invalid configuration keeps the current one
+	if err := ioutil.WriteFile(filename, []byte(invalidConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.reload(filename, nil); err == nil {
+		t.Fatal("expected the invalid configuration to be rejected")
+	}
+	if n := len(sc.get().Targets); n != 2 {
+		t.Errorf("expected the 2 targets of the previous configuration, got %d", n)
+	}
+	if v := testutil.ToFloat64(configReloadSuccess); v != 0 {
+		t.Errorf("expected the reload to be failed, got %v", v)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	filename := writeConfig(t, validConfig)
+	defer os.Remove(filename)
+	sc := &safeConfig{}
+	handler := reloadHandler(sc, filename, nil)
+
+	tests := []struct {
+		method   string
+		content  string
+		expected int
+	}{
+		{http.MethodGet, validConfig, http.StatusMethodNotAllowed},
+		{http.MethodPost, validConfig, http.StatusOK},
+		{http.MethodPost, invalidConfig, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(test.method, "/-/reload", nil))
+		if w.Code != test.expected {
+			t.Errorf("%s /-/reload: got status %d, want %d", test.method, w.Code, test.expected)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// sdTargetGroup is a target group of the Prometheus HTTP service discovery
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler lists the configured and discovered targets in the format of the Prometheus http_sd_config.
+// Every group carries the static labels of the target and the target parameter it is scraped with.
+// The module URL parameter is passed on as __param_module.
+func sdHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "403 Forbidden", 403)
+		return
+	}
+	module := r.URL.Query().Get("module")
+	if _, found := sc.get().Modules[module]; module != "" && !found {
+		http.Error(w, "The module '"+module+"' is not defined in the configuration file", 400)
+		return
+	}
+
+	groups := []sdTargetGroup{}
+	for _, t := range sc.targets() {
+		labels := make(map[string]string, len(t.Labels)+2)
+		for name, value := range t.Labels {
+			labels[name] = value
+		}
+		labels["__param_target"] = t.IpAddress
+		if module != "" {
+			labels["__param_module"] = module
+		}
+		groups = append(groups, sdTargetGroup{Targets: []string{t.IpAddress}, Labels: labels})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		level.Error(logging.Base()).Log("msg", "Writing the service discovery response failed", "err", err)
+	}
+}
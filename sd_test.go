@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestSDHandler(t *testing.T) {
+	current := sc
+	defer func() { sc = current }()
+	sc = &safeConfig{config: &connector.Config{
+		Modules: map[string]connector.Module{"light": {Collectors: []string{"uptime"}}},
+		Targets: []connector.Targets{
+			{IpAddress: "172.16.64.17", Discover: true, Labels: map[string]string{"fabric": "a"}},
+			{IpAddress: "172.16.64.20"},
+		},
+	}}
+	sc.setDiscovered("172.16.64.17", []fabricMember{{Address: "172.16.64.17", Name: "SAN1"}, {Address: "172.16.64.18", Name: "SAN2"}})
+
+	tests := []struct {
+		query    string
+		code     int
+		expected []sdTargetGroup
+	}{
+		{"", 200, []sdTargetGroup{
+			{Targets: []string{"172.16.64.17"}, Labels: map[string]string{"fabric": "a", "__param_target": "172.16.64.17"}},
+			{Targets: []string{"172.16.64.20"}, Labels: map[string]string{"__param_target": "172.16.64.20"}},
+			{Targets: []string{"172.16.64.18"}, Labels: map[string]string{"__param_target": "172.16.64.18"}},
+		}},
+		{"?module=light", 200, []sdTargetGroup{
+			{Targets: []string{"172.16.64.17"}, Labels: map[string]string{"fabric": "a", "__param_target": "172.16.64.17", "__param_module": "light"}},
+			{Targets: []string{"172.16.64.20"}, Labels: map[string]string{"__param_target": "172.16.64.20", "__param_module": "light"}},
+			{Targets: []string{"172.16.64.18"}, Labels: map[string]string{"__param_target": "172.16.64.18", "__param_module": "light"}},
+		}},
+		{"?module=heavy", 400, nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		sdHandler(w, httptest.NewRequest("GET", "/sd"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.query, w.Code, test.code)
+			continue
+		}
+		if test.code != 200 {
+			continue
+		}
+		var groups []sdTargetGroup
+		if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+			t.Fatalf("%s: %v", test.query, err)
+		}
+		if !reflect.DeepEqual(groups, test.expected) {
+			t.Errorf("%s: groups = %+v, want %+v", test.query, groups, test.expected)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	sdHandler(w, httptest.NewRequest("POST", "/sd", nil))
+	if w.Code != 403 {
+		t.Errorf("POST: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/version"
+	"github.ibm.com/ZaaS/fabric-os-exporter/collector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+	"gopkg.in/yaml.v2"
+)
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string {
+		return time.Since(t).Truncate(time.Second).String() + " ago"
+	},
+	"seconds": func(d time.Duration) string {
+		return fmt.Sprintf("%.3fs", d.Seconds())
+	},
+}).Parse(`<html>
+<head>
+	<title>fabric os exporter</title>
+	<style>
+		table { border-collapse: collapse; }
+		th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+		.ok { color: #080; }
+		.failed { color: #c00; }
+	</style>
+</head>
+<body>
+	<h1>fabric os exporter</h1>
+	<p>{{.Version}}</p>
+	<p><a href='{{.MetricsPath}}'>Metrics</a> | <a href='/sd'>Service discovery</a></p>
+	<h2>Targets</h2>
+	<table>
+		<tr><th>Target</th><th>Switch</th><th>Fabric OS</th><th>Last scrape</th><th>Duration</th><th>Result</th><th>Collectors</th><th>Connection</th></tr>
+		{{range .Targets}}
+		<tr>
+			<td>{{.Target}}{{if .Principal}} (principal){{end}}</td>
+			{{if .Scraped}}
+			<td>{{.Status.SwitchName}}</td>
+			<td>{{.Status.Version}}</td>
+			<td>{{.Status.LastScrape.Format "2006-01-02 15:04:05"}} ({{ago .Status.LastScrape}})</td>
+			<td>{{seconds .Status.Duration}}</td>
+			<td>{{if and .Status.Success (not .Status.Error)}}<span class="ok">ok</span>{{else}}<span class="failed">failed</span> {{.Status.Error}}{{end}}</td>
+			<td>
+				{{range .Status.Collectors}}
+				{{.Name}}: {{if .Success}}<span class="ok">ok</span>{{else if .TimedOut}}<span class="failed">timed out</span>{{else}}<span class="failed">failed</span>{{end}}
+				{{seconds .Duration}}{{if .Error}}, {{.Error}}{{end}}<br>
+				{{end}}
+			</td>
+			{{else}}
+			<td colspan="6">not scraped yet</td>
+			{{end}}
+			<td>{{.Connection}}</td>
+		</tr>
+		{{end}}
+	</table>
+	<h2>Configuration</h2>
+	<pre>{{.Config}}</pre>
+</body>
+</html>`))
+
+// targetStatus is a row of the status page
+type targetStatus struct {
+	Target     string
+	Principal  bool
+	Scraped    bool
+	Status     collector.TargetStatus
+	Connection string
+}
+
+// statusHandler serves the landing page with the result of the last scrape of every target and
+// the running configuration, with the secrets redacted
+func statusHandler(connectionManager *connector.SSHConnectionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "403 Forbidden", 403)
+			return
+		}
+
+		var targets []targetStatus
+		for _, t := range sc.targets() {
+			status, scraped := collector.LastStatus(t.IpAddress)
+			targets = append(targets, targetStatus{
+				Target:     t.IpAddress,
+				Principal:  sc.principal(t.IpAddress),
+				Scraped:    scraped,
+				Status:     status,
+				Connection: connectionState(connectionManager, t),
+			})
+		}
+		config, err := yaml.Marshal(sc.get())
+		if err != nil {
+			config = []byte(fmt.Sprintf("error marshaling the configuration: %s", err))
+		}
+
+		err = statusTemplate.Execute(w, struct {
+			Version     string
+			MetricsPath string
+			Targets     []targetStatus
+			Config      string
+		}{version.Info(), *metricsPath, targets, string(config)})
+		if err != nil {
+			level.Error(logging.Base()).Log("msg", "Error rendering the status page", "err", err)
+		}
+	}
+}
+
+func connectionState(connectionManager *connector.SSHConnectionManager, t connector.Targets) string {
+	if t.Transport == connector.TransportSNMP {
+		return "SNMP, connected for each scrape"
+	}
+	pool, found := connectionManager.PoolStatus(t)
+	if !found || pool.Connections == 0 {
+		return "not connected"
+	}
+	state := fmt.Sprintf("%d open, %d in use, %.0f reconnects", pool.Connections, pool.InUse, pool.Reconnects)
+	if pool.KeepAliveRTT > 0 {
+		state += fmt.Sprintf(", keepalive %s", pool.KeepAliveRTT.Truncate(time.Millisecond))
+	}
+	return state
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestStatusHandler(t *testing.T) {
+	current := sc
+	defer func() { sc = current }()
+	sc = &safeConfig{config: &connector.Config{Targets: []connector.Targets{
+		{IpAddress: "192.0.2.1", Transport: connector.TransportSSH, Credentials: connector.Credentials{Userid: "admin", Password: "hunter2"}},
+		{IpAddress: "192.0.2.2", Transport: connector.TransportSNMP},
+	}}}
+	connectionManager, err := connector.NewConnectionManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connectionManager.Close()
+
+	w := httptest.NewRecorder()
+	statusHandler(connectionManager)(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{"192.0.2.1", "192.0.2.2", "not scraped yet", "not connected", "SNMP, connected for each scrape"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %q on the status page", expected)
+		}
+	}
+	if strings.Contains(body, "hunter2") {
+		t.Error("expected the password to be redacted on the status page")
+	}
+
+	w = httptest.NewRecorder()
+	statusHandler(connectionManager)(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != 403 {
+		t.Errorf("POST: status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+)
+
+// resolveTimeout bounds the DNS lookup of a requested target
+const resolveTimeout = 2 * time.Second
+
+// findTarget returns the target requested with the target URL parameter. It is matched, in this order,
+// against the address, the aliases and the discovered switch names of the targets, then by host and port,
+// and finally by the IP addresses the requested name resolves to.
+func findTarget(targets []connector.Targets, reqTarget string) (connector.Targets, bool) {
+	for _, t := range targets {
+		if t.IpAddress == reqTarget {
+			return t, true
+		}
+	}
+	for _, t := range targets {
+		for _, alias := range t.Aliases {
+			if strings.EqualFold(alias, reqTarget) {
+				return t, true
+			}
+		}
+	}
+	for _, t := range targets {
+		if name := sc.switchName(t.IpAddress); name != "" && name == reqTarget {
+			return t, true
+		}
+	}
+
+	// A request without port matches any port
+	reqHost, reqPort, err := connector.SplitAddress(reqTarget, 0)
+	if err != nil {
+		return connector.Targets{}, false
+	}
+	for _, t := range targets {
+		host, port, err := connector.SplitAddress(t.IpAddress, t.Port)
+		if err == nil && host == reqHost && (reqPort == 0 || reqPort == port) {
+			return t, true
+		}
+	}
+
+	if net.ParseIP(reqHost) != nil {
+		return connector.Targets{}, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupHost(ctx, reqHost)
+	if err != nil {
+		level.Debug(logging.Base()).Log("msg", "Resolving the target failed", "host", reqHost, "err", err)
+		return connector.Targets{}, false
+	}
+	for _, t := range targets {
+		host, port, _ := connector.SplitAddress(t.IpAddress, t.Port)
+		ip := net.ParseIP(host)
+		if ip == nil || (reqPort != 0 && reqPort != port) {
+			continue
+		}
+		for _, address := range addresses {
+			if ip.Equal(net.ParseIP(address)) {
+				return t, true
+			}
+		}
+	}
+	return connector.Targets{}, false
+}
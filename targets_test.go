@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.ibm.com/ZaaS/fabric-os-exporter/connector"
+)
+
+func TestFindTarget(t *testing.T) {
+	current := sc
+	defer func() { sc = current }()
+	targets := []connector.Targets{
+		{IpAddress: "172.16.64.17", Port: 22, Discover: true},
+		{IpAddress: "127.0.0.1", Port: 2222, Aliases: []string{"fra1-edge-a"}},
+		{IpAddress: "san3.example.com:22", Port: 22},
+		{IpAddress: "[fd00::12]:22", Port: 22},
+	}
+	sc = &safeConfig{config: &connector.Config{Targets: targets}}
+	sc.setDiscovered("172.16.64.17", []fabricMember{{Address: "172.16.64.17", Name: "SAN1"}})
+
+	tests := []struct {
+		reqTarget string
+		expected  string
+	}{
+		{"172.16.64.17", "172.16.64.17"},
+		{"FRA1-EDGE-A", "127.0.0.1"},
+		{"SAN1", "172.16.64.17"},
+		{"172.16.64.17:22", "172.16.64.17"},
+		{"172.16.64.17:2222", ""},
+		{"san3.example.com.", "san3.example.com:22"},
+		{"fd00::12", "[fd00::12]:22"},
+		{"[fd00::12]:22", "[fd00::12]:22"},
+		// resolved to the address of a target
+		{"localhost", "127.0.0.1"},
+		{"localhost:2222", "127.0.0.1"},
+		{"localhost:22", ""},
+		{"172.16.64.99", ""},
+	}
+	for _, test := range tests {
+		target, found := findTarget(targets, test.reqTarget)
+		if found != (test.expected != "") || target.IpAddress != test.expected {
+			t.Errorf("findTarget(%q) = %q, %v, want %q", test.reqTarget, target.IpAddress, found, test.expected)
+		}
+	}
+}
+
+func TestAdHocTargets(t *testing.T) {
+	current := sc
+	defer func() { sc = current }()
+	enabled := *adHocTargets
+	defer func() { *adHocTargets = enabled }()
+	filename := writeConfig(t, `
+auths:
+  san:
+    userid: monitor
+    password: secret
+targets: []
+`)
+	defer os.Remove(filename)
+	sc = &safeConfig{}
+	if err := sc.reload(filename, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	*adHocTargets = false
+	if _, err := targetsForRequest(httptest.NewRequest("GET", "/metrics?target=192.0.2.9&auth=san", nil)); err == nil {
+		t.Error("expected ad-hoc targets to be rejected unless they are enabled")
+	}
+
+	*adHocTargets = true
+	targets, err := targetsForRequest(httptest.NewRequest("GET", "/metrics?target=192.0.2.9&auth=san", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].IpAddress != "192.0.2.9" || targets[0].Userid != "monitor" {
+		t.Errorf("expected an ad-hoc target with the credentials of the profile, got %+v", targets)
+	}
+	for _, query := range []string{"?target=192.0.2.9", "?target=192.0.2.9&auth=unknown", "?target=192.0.2.9:99999&auth=san"} {
+		if _, err := targetsForRequest(httptest.NewRequest("GET", "/metrics"+query, nil)); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.ibm.com/ZaaS/fabric-os-exporter/logging"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig is the web configuration file, in the format of the Prometheus exporter toolkit
+type webConfig struct {
+	TLSConfig tlsServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers maps the user names to their bcrypt password hashes
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+type tlsServerConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ClientAuth string `yaml:"client_auth_type"`
+	ClientCAs  string `yaml:"client_ca_file"`
+	MinVersion string `yaml:"min_version"`
+}
+
+var (
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+	tlsVersions = map[string]uint16{
+		"":      tls.VersionTLS12,
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+)
+
+// loadWebConfig reads and checks the web configuration file
+func loadWebConfig(filename string) (*webConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &webConfig{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", filename)
+	}
+
+	t := c.TLSConfig
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("tls_server_config: cert_file and key_file must be set together")
+	}
+	clientAuth, found := clientAuthTypes[t.ClientAuth]
+	if !found {
+		return nil, errors.Errorf("tls_server_config: unknown client_auth_type %q", t.ClientAuth)
+	}
+	if (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) && t.ClientCAs == "" {
+		return nil, errors.Errorf("tls_server_config: client_ca_file is required with client_auth_type %s", t.ClientAuth)
+	}
+	if _, found := tlsVersions[t.MinVersion]; !found {
+		return nil, errors.Errorf("tls_server_config: unknown min_version %q", t.MinVersion)
+	}
+	if t.CertFile == "" && (t.ClientCAs != "" || t.ClientAuth != "") {
+		return nil, errors.New("tls_server_config: client certificates require cert_file and key_file")
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, errors.Wrapf(err, "basic_auth_users: invalid bcrypt hash of %s", user)
+		}
+	}
+	return c, nil
+}
+
+func (c *webConfig) tlsEnabled() bool {
+	return c.TLSConfig.CertFile != ""
+}
+
+// tlsConfig loads the certificates
+func (c *webConfig) tlsConfig() (*tls.Config, error) {
+	t := c.TLSConfig
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading the TLS certificate")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuthTypes[t.ClientAuth],
+		MinVersion:   tlsVersions[t.MinVersion],
+	}
+	if t.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(t.ClientCAs)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading client_ca_file")
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("client_ca_file %s contains no certificate", t.ClientCAs)
+		}
+	}
+	return config, nil
+}
+
+// webConfigCache keeps the web configuration and the TLS certificates it references. They are read
+// again once one of the files changed, so that renewed certificates and new users are used without restart.
+// If the changed files are invalid, the last good configuration stays in use.
+type webConfigCache struct {
+	filename string
+	// tls is set if the server was started with TLS, which can't be switched off or on without restart
+	tls bool
+
+	mu     sync.Mutex
+	config *webConfig
+	tlsCfg *tls.Config
+	// stamps are the modification times and sizes of the files the configuration was read from
+	stamps map[string]fileStamp
+	// checked is the time the files were last checked for changes, at most once per interval
+	checked  time.Time
+	interval time.Duration
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// newWebConfigCache reads the web configuration file, it fails if the file or its certificates are invalid
+func newWebConfigCache(filename string) (*webConfigCache, error) {
+	cache := &webConfigCache{filename: filename, interval: webConfigCheckInterval}
+	c, tlsCfg, err := cache.load()
+	if err != nil {
+		return nil, err
+	}
+	cache.tls = c.tlsEnabled()
+	cache.config, cache.tlsCfg, cache.stamps = c, tlsCfg, cache.currentStamps(c)
+	cache.checked = time.Now()
+	return cache, nil
+}
+
+// get returns the web configuration and its TLS configuration, which is nil without TLS.
+// The files are checked for changes at most once per interval.
+func (cache *webConfigCache) get() (*webConfig, *tls.Config) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if time.Since(cache.checked) < cache.interval {
+		return cache.config, cache.tlsCfg
+	}
+	cache.checked = time.Now()
+	stamps := cache.currentStamps(cache.config)
+	if reflect.DeepEqual(stamps, cache.stamps) {
+		return cache.config, cache.tlsCfg
+	}
+	// A failed reload is not retried until the files change again
+	cache.stamps = stamps
+	c, tlsCfg, err := cache.load()
+	if err != nil {
+		level.Error(logging.Base()).Log("msg", "Error reloading the web config file, the previous configuration stays in use", "err", err)
+		return cache.config, cache.tlsCfg
+	}
+	// The certificate files may be named in the new configuration only
+	cache.stamps = cache.currentStamps(c)
+	cache.config, cache.tlsCfg = c, tlsCfg
+	level.Info(logging.Base()).Log("msg", "Web config file reloaded")
+	return cache.config, cache.tlsCfg
+}
+
+func (cache *webConfigCache) load() (*webConfig, *tls.Config, error) {
+	c, err := loadWebConfig(cache.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cache.config != nil && c.tlsEnabled() != cache.tls {
+		return nil, nil, errors.New("TLS can't be enabled or disabled without restart")
+	}
+	if !c.tlsEnabled() {
+		return c, nil, nil
+	}
+	tlsCfg, err := c.tlsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, tlsCfg, nil
+}
+
+// currentStamps returns the modification times and sizes of the web configuration file and the files it references
+func (cache *webConfigCache) currentStamps(c *webConfig) map[string]fileStamp {
+	files := []string{cache.filename}
+	if c != nil {
+		files = append(files, c.TLSConfig.CertFile, c.TLSConfig.KeyFile, c.TLSConfig.ClientCAs)
+	}
+	stamps := make(map[string]fileStamp)
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+
+	// webConfigCheckInterval is the minimum time between two checks whether the web configuration files changed
+	webConfigCheckInterval = 5 * time.Second
+
+	// unauthenticatedPaths are served without basic auth, the probes of Kubernetes can't send credentials
+	unauthenticatedPaths = map[string]bool{
+		"/-/healthy": true,
+		"/-/ready":   true,
+	}
+)
+
+// webHandler requires the basic auth users of the web configuration file, except for the health endpoints
+type webHandler struct {
+	config  *webConfigCache
+	handler http.Handler
+	// verified caches the successful bcrypt comparisons, which are slow on purpose
+	verified   map[string]bool
+	verifiedMu sync.Mutex
+}
+
+func (h *webHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, _ := h.config.get()
+	if len(c.BasicAuthUsers) > 0 && !unauthenticatedPaths[r.URL.Path] {
+		user, password, ok := r.BasicAuth()
+		if !ok || !h.authenticate(c.BasicAuthUsers[user], password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fabric os exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *webHandler) authenticate(hash string, password string) bool {
+	sum := sha256.Sum256([]byte(password))
+	key := hash + "\x00" + hex.EncodeToString(sum[:])
+
+	h.verifiedMu.Lock()
+	cached := h.verified[key]
+	h.verifiedMu.Unlock()
+	if cached {
+		return true
+	}
+	if hash == "" {
+		// Unknown users are compared against a dummy hash, so that they take as long as known ones
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	h.verifiedMu.Lock()
+	defer h.verifiedMu.Unlock()
+	if h.verified == nil {
+		h.verified = make(map[string]bool)
+	}
+	h.verified[key] = true
+	return true
+}
+
+// listen serves the handler, with TLS and basic auth if a web configuration file is given
+func listen(address string, webConfigFile string, handler http.Handler) error {
+	if webConfigFile == "" {
+		return http.ListenAndServe(address, handler)
+	}
+	cache, err := newWebConfigCache(webConfigFile)
+	if err != nil {
+		return err
+	}
+	return serve(address, cache, handler)
+}
+
+// serve serves the handler with the TLS settings and basic auth users of the web configuration
+func serve(address string, cache *webConfigCache, handler http.Handler) error {
+	server := &http.Server{
+		Addr:    address,
+		Handler: &webHandler{config: cache, handler: handler},
+	}
+	if !cache.tls {
+		level.Info(logging.Base()).Log("msg", "TLS is disabled")
+		return server.ListenAndServe()
+	}
+
+	// Every handshake uses the certificates which were loaded last
+	server.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, tlsCfg := cache.get()
+			return tlsCfg, nil
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			_, tlsCfg := cache.get()
+			return &tlsCfg.Certificates[0], nil
+		},
+	}
+	level.Info(logging.Base()).Log("msg", "TLS is enabled")
+	return server.ListenAndServeTLS("", "")
+}
+
+// sameOrigin protects state changing endpoints against cross-site request forgery: requests which
+// a browser sends on behalf of another site are rejected. Tools like curl don't send these headers,
+// so requests without Origin and Sec-Fetch-Site are let through. Current browsers send at least one
+// of them with every POST, also together with cached basic auth credentials.
+func sameOrigin(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+			http.Error(w, "Cross-site requests are not allowed.", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "Cross-site requests are not allowed.", http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
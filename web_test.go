@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCertificate stores a self-signed certificate with the given serial number and its key in dir
+func writeCertificate(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content string
+		fails   bool
+	}{
+		{"basic auth", "basic_auth_users:\n  prometheus: " + string(hash) + "\n", false},
+		{"tls", "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  min_version: TLS13\n", false},
+		{"unknown key", "basic_auth_user: {}\n", true},
+		{"cert without key", "tls_server_config:\n  cert_file: cert.pem\n", true},
+		{"unknown client auth", "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  client_auth_type: Always\n", true},
+		{"verify without ca", "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  client_auth_type: RequireAndVerifyClientCert\n", true},
+		{"unknown min version", "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  min_version: SSL3\n", true},
+		{"client ca without tls", "tls_server_config:\n  client_ca_file: ca.pem\n", true},
+		{"plain password", "basic_auth_users:\n  prometheus: secret\n", true},
+	}
+	for _, test := range tests {
+		filename := writeConfig(t, test.content)
+		_, err := loadWebConfig(filename)
+		os.Remove(filename)
+		if test.fails != (err != nil) {
+			t.Errorf("%s: loadWebConfig() error = %v, fails %v", test.name, err, test.fails)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := writeConfig(t, "basic_auth_users:\n  prometheus: "+string(hash)+"\n")
+	defer os.Remove(filename)
+	cache, err := newWebConfigCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.interval = 0
+	h := &webHandler{config: cache, handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		code     int
+	}{
+		{"valid", "prometheus", "secret", 200},
+		{"cached", "prometheus", "secret", 200},
+		{"wrong password", "prometheus", "wrong", 401},
+		{"unknown user", "grafana", "secret", 401},
+		{"no credentials", "", "", 401},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.code)
+		}
+		if test.code == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", test.name)
+		}
+	}
+	if len(h.verified) != 1 {
+		t.Errorf("expected only the valid password to be cached, got %d entries", len(h.verified))
+	}
+	// unknown users are compared against the dummy hash
+	if dummyHash == nil {
+		t.Error("expected the dummy hash to be used for the unknown user")
+	}
+
+	// the users are read again, a removed user is rejected
+	if err := ioutil.WriteFile(filename, []byte("basic_auth_users:\n  other: "+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.SetBasicAuth("prometheus", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 401 {
+		t.Errorf("expected the removed user to be rejected, got status %d", w.Code)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	h := sameOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{"no headers", nil, 200},
+		{"same origin", map[string]string{"Origin": "http://exporter:9879", "Sec-Fetch-Site": "same-origin"}, 200},
+		{"typed by the user", map[string]string{"Sec-Fetch-Site": "none"}, 200},
+		{"cross site", map[string]string{"Sec-Fetch-Site": "cross-site"}, 403},
+		{"same site", map[string]string{"Sec-Fetch-Site": "same-site"}, 403},
+		{"other origin", map[string]string{"Origin": "http://evil.example.com"}, 403},
+		{"invalid origin", map[string]string{"Origin": "http://%zz"}, 403},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://exporter:9879/-/reload", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.code)
+		}
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir, 1)
+	filename := filepath.Join(dir, "web.yaml")
+	content := "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	cache, err := newWebConfigCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.interval = 0
+	go serve(address, cache, http.NotFoundHandler())
+
+	serial := func() int64 {
+		var conn *tls.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			conn, err = tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+			if err == nil || !strings.Contains(err.Error(), "refused") {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if n := serial(); n != 1 {
+		t.Fatalf("expected the certificate 1, got %d", n)
+	}
+	// a renewed certificate is used for new connections without a restart
+	writeCertificate(t, dir, 2)
+	if n := serial(); n != 2 {
+		t.Errorf("expected the renewed certificate 2, got %d", n)
+	}
+}
+
+func TestWebConfigCache(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir, 1)
+	filename := filepath.Join(dir, "web.yaml")
+	tlsConfig := "tls_server_config:\n  cert_file: " + certFile + "\n  key_file: " + keyFile + "\n"
+	if err := ioutil.WriteFile(filename, []byte(tlsConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := newWebConfigCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.interval = 0
+	c, tlsCfg := cache.get()
+	if !cache.tls || tlsCfg == nil || len(c.BasicAuthUsers) != 0 {
+		t.Fatalf("expected TLS without users, got %+v", c)
+	}
+
+	// new users are picked up
+	withUsers := tlsConfig + "basic_auth_users:\n  prometheus: " + string(hash) + "\n"
+	if err := ioutil.WriteFile(filename, []byte(withUsers), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := cache.get(); len(c.BasicAuthUsers) != 1 {
+		t.Errorf("expected the added user, got %+v", c)
+	}
+
+	// invalid changes and switching TLS off keep the last good configuration
+	for _, content := range []string{"basic_auth_users: [", "basic_auth_users:\n  prometheus: " + string(hash) + "\n"} {
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c, tlsCfg := cache.get()
+		if len(c.BasicAuthUsers) != 1 || tlsCfg == nil {
+			t.Errorf("%q: expected the previous configuration to stay in use, got %+v", content, c)
+		}
+	}
+
+	if _, err := newWebConfigCache(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("expected a missing file to be rejected")
+	}
+}
+
+func TestWebConfigCacheInterval(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := writeConfig(t, "basic_auth_users:\n  prometheus: "+string(hash)+"\n")
+	defer os.Remove(filename)
+	cache, err := newWebConfigCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.interval = time.Hour
+
+	// the files aren't checked again before the interval passed
+	if err := ioutil.WriteFile(filename, []byte("basic_auth_users:\n  other: "+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := cache.get(); c.BasicAuthUsers["prometheus"] == "" {
+		t.Errorf("expected the files not to be read again, got %+v", c)
+	}
+	cache.mu.Lock()
+	cache.checked = time.Now().Add(-2 * time.Hour)
+	cache.mu.Unlock()
+	if c, _ := cache.get(); c.BasicAuthUsers["other"] == "" {
+		t.Errorf("expected the changed user once the interval passed, got %+v", c)
+	}
+}
+
+func TestHealthWithoutAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := writeConfig(t, "basic_auth_users:\n  prometheus: "+string(hash)+"\n")
+	defer os.Remove(filename)
+	cache, err := newWebConfigCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &webHandler{config: cache, handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+
+	for path, code := range map[string]int{"/-/healthy": 200, "/-/ready": 200, "/metrics": 401, "/-/reload": 401, "/-/status": 401} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: status %d, want %d", path, w.Code, code)
+		}
+	}
+}